@@ -0,0 +1,174 @@
+// Package dbconn holds the PostgreSQL connection settings shared by the
+// FactSet commands, and the command line options that set them.
+package dbconn
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jawher/mow.cli"
+	_ "github.com/lib/pq"
+)
+
+// Config describes how to connect to PostgreSQL.
+type Config struct {
+	Host         string
+	Port         int
+	User         string
+	Password     string
+	PasswordFile string // read for the password if Password is empty
+
+	SSLMode     string
+	SSLCert     string
+	SSLKey      string
+	SSLRootCert string
+
+	MaxOpenConns int // 0 means unlimited
+	MaxIdleConns int // 0 means the same as MaxOpenConns
+}
+
+// Options registers the command line options and environment variables
+// that configure the connection for cmd. The standard libpq environment
+// variables are honoured too.
+func Options(cmd *cli.Cmd) *Config {
+	c := &Config{}
+	cmd.StringPtr(&c.Host, cli.StringOpt{
+		Name:   "db-host",
+		Value:  "localhost",
+		Desc:   "postgres host",
+		EnvVar: "FSIMPORT_DB_HOST PGHOST",
+	})
+	cmd.IntPtr(&c.Port, cli.IntOpt{
+		Name:   "db-port",
+		Value:  5432,
+		Desc:   "postgres port",
+		EnvVar: "FSIMPORT_DB_PORT PGPORT",
+	})
+	cmd.StringPtr(&c.User, cli.StringOpt{
+		Name:   "db-user",
+		Value:  "postgres",
+		Desc:   "postgres user",
+		EnvVar: "FSIMPORT_DB_USER PGUSER",
+	})
+	cmd.StringPtr(&c.Password, cli.StringOpt{
+		Name:      "db-password",
+		Value:     "password",
+		Desc:      "postgres password",
+		EnvVar:    "FSIMPORT_DB_PASSWORD PGPASSWORD",
+		HideValue: true,
+	})
+	cmd.StringPtr(&c.PasswordFile, cli.StringOpt{
+		Name:   "db-password-file",
+		Desc:   "file holding the postgres password, used instead of --db-password",
+		EnvVar: "FSIMPORT_DB_PASSWORD_FILE",
+	})
+	cmd.StringPtr(&c.SSLMode, cli.StringOpt{
+		Name:   "db-sslmode",
+		Value:  "disable",
+		Desc:   "postgres sslmode: disable, require, verify-ca or verify-full",
+		EnvVar: "FSIMPORT_DB_SSLMODE PGSSLMODE",
+	})
+	cmd.StringPtr(&c.SSLCert, cli.StringOpt{
+		Name:   "db-sslcert",
+		Desc:   "client certificate file",
+		EnvVar: "FSIMPORT_DB_SSLCERT PGSSLCERT",
+	})
+	cmd.StringPtr(&c.SSLKey, cli.StringOpt{
+		Name:   "db-sslkey",
+		Desc:   "client private key file",
+		EnvVar: "FSIMPORT_DB_SSLKEY PGSSLKEY",
+	})
+	cmd.StringPtr(&c.SSLRootCert, cli.StringOpt{
+		Name:   "db-sslrootcert",
+		Desc:   "root certificate file used to verify the server",
+		EnvVar: "FSIMPORT_DB_SSLROOTCERT PGSSLROOTCERT",
+	})
+	cmd.IntPtr(&c.MaxOpenConns, cli.IntOpt{
+		Name:   "db-max-open-conns",
+		Value:  0,
+		Desc:   "maximum number of open connections. 0 means unlimited, or sized to the number of workers for commands that have them",
+		EnvVar: "FSIMPORT_DB_MAX_OPEN_CONNS",
+	})
+	cmd.IntPtr(&c.MaxIdleConns, cli.IntOpt{
+		Name:   "db-max-idle-conns",
+		Value:  0,
+		Desc:   "maximum number of idle connections. 0 means the same as --db-max-open-conns",
+		EnvVar: "FSIMPORT_DB_MAX_IDLE_CONNS",
+	})
+	return c
+}
+
+// DSN returns the lib/pq connection URL for the named database. If
+// searchPath is not empty, unqualified table names are resolved against it.
+func (c Config) DSN(dbName string, searchPath string) (string, error) {
+	password := c.Password
+	if c.PasswordFile != "" {
+		b, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	}
+
+	q := url.Values{}
+	q.Set("sslmode", c.SSLMode)
+	if c.SSLCert != "" {
+		q.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		q.Set("sslkey", c.SSLKey)
+	}
+	if c.SSLRootCert != "" {
+		q.Set("sslrootcert", c.SSLRootCert)
+	}
+	if searchPath != "" {
+		q.Set("search_path", searchPath)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + dbName,
+		RawQuery: q.Encode(),
+	}
+	return u.String(), nil
+}
+
+// Open connects to the named database, checking that it is reachable, and
+// applies the connection pool settings.
+func (c Config) Open(dbName string, searchPath string) (*sql.DB, error) {
+	dsn, err := c.DSN(dbName, searchPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	idle := c.MaxIdleConns
+	if idle == 0 {
+		idle = c.MaxOpenConns
+	}
+	if idle > 0 {
+		db.SetMaxIdleConns(idle)
+	}
+	return db, nil
+}
+
+// SizePool limits the connection pool to conns connections, unless a limit
+// was set explicitly.
+func (c *Config) SizePool(conns int) {
+	if c.MaxOpenConns == 0 {
+		c.MaxOpenConns = conns
+	}
+}
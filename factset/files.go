@@ -0,0 +1,273 @@
+package factset
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// File maps a FactSet file onto the table its rows are loaded into. Fields
+// are matched to columns by the file's header, so Columns need only list the
+// columns the table is created with.
+type File struct {
+	Name    string   `json:"file"`
+	Table   string   `json:"table"`
+	Columns []Column `json:"columns"`
+	// Key lists the columns identifying the rows that a record replaces, or
+	// that an entry in the file's delta "_delete" file removes.
+	Key []string `json:"key"`
+	// Unique is set if the key identifies a single row.
+	Unique bool `json:"unique"`
+	// Index names the index on the key. Defaults to <table>_key.
+	Index string `json:"index,omitempty"`
+}
+
+// Column is a table column and its SQL type.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Files are the FactSet files that are loaded, and their tables.
+var Files = []File{
+	{
+		Name:  "edm_entity.txt",
+		Table: "fsEntity",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"ENTITY_NAME", "text"},
+			{"ENTITY_PROPER_NAME", "text"},
+			{"PRIMARY_SIC_CODE", "char(4)"},
+			{"INDUSTRY_CODE", "char(4)"},
+			{"SECTOR_CODE", "char(4)"},
+			{"ISO_COUNTRY", "char(2)"},
+			{"METRO_AREA", "text"},
+			{"STATE_PROVINCE", "text"},
+			{"ZIP_POSTAL_CODE", "text"},
+			{"WEB_SITE", "text"},
+			{"ENTITY_TYPE", "char(3)"},
+			{"ENTITY_SUB_TYPE", "char(2)"},
+			{"YEAR_FOUNDED", "integer"},
+			{"ISO_COUNTRY_INCORP", "char(2)"},
+			{"ISO_COUNTRY_COR", "char(2)"},
+			{"NACE_CODE", "text"},
+		},
+		Key:    []string{"FACTSET_ENTITY_ID"},
+		Unique: true,
+		Index:  "fsEntity_fsid",
+	},
+	{
+		Name:  "edm_entity_structure.txt",
+		Table: "fsStructure",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"FACTSET_PARENT_ENTITY_ID", "char(8)"},
+			{"FACTSET_ULTIMATE_PARENT_ENTITY_ID", "char(8)"},
+		},
+		Key:    []string{"FACTSET_ENTITY_ID"},
+		Unique: true,
+		Index:  "fsStructure_fsid",
+	},
+	{
+		Name:  "edm_entity_names.txt",
+		Table: "fsNames",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"ENTITY_NAME_TYPE", "text"},
+			{"ENTITY_NAME_VALUE", "text"},
+		},
+		Key:   []string{"FACTSET_ENTITY_ID"},
+		Index: "fsNames_fsid",
+	},
+	{
+		Name:  "edm_entity_changes.txt",
+		Table: "fsChanges",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"CHANGE_TYPE", "text"},
+			{"CHANGE_DATE", "timestamp"},
+			{"OLD_VALUE", "text"},
+			{"NEW_VALUE", "text"},
+			{"AUDIT_TYPE", "text"},
+			{"COMMENTS", "text"},
+			{"AUDIT_ID", "text"},
+		},
+		Key:   []string{"FACTSET_ENTITY_ID"},
+		Index: "fsChanges_fsid",
+	},
+	{
+		Name:  "edm_entity_identifiers.txt",
+		Table: "fsIdentifiers",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"ENTITY_ID_TYPE", "text"},
+			{"ENTITY_ID_VALUE", "text"},
+		},
+		Key:   []string{"FACTSET_ENTITY_ID"},
+		Index: "fsIdentifiers_fsid",
+	},
+	{
+		Name:  "edm_entity_address.txt",
+		Table: "fsAddresses",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"LOCATION_STREET1", "text"},
+			{"LOCATION_STREET2", "text"},
+			{"LOCATION_STREET3", "text"},
+			{"LOCATION_CITY", "text"},
+			{"STATE_PROVINCE", "text"},
+			{"LOCATION_POSTAL_CODE", "text"},
+			{"ISO_COUNTRY", "char(2)"},
+			{"TELEPHONE", "text"},
+			{"HQ_FLAG", "text"},
+		},
+		Key:   []string{"FACTSET_ENTITY_ID"},
+		Index: "fsAddresses_fsid",
+	},
+	{
+		Name:  "edm_entity_coverage.txt",
+		Table: "fsCoverage",
+		Columns: []Column{
+			{"FACTSET_ENTITY_ID", "char(8)"},
+			{"ENTITY_STATUS", "text"},
+			{"COVERAGE_LEVEL", "text"},
+			{"COVERAGE_START_DATE", "date"},
+			{"COVERAGE_END_DATE", "date"},
+		},
+		Key:    []string{"FACTSET_ENTITY_ID"},
+		Unique: true,
+		Index:  "fsCoverage_fsid",
+	},
+	{
+		Name:  "edm_industry_map.txt",
+		Table: "fsIndustries",
+		Columns: []Column{
+			{"INDUSTRY_CODE", "char(4)"},
+			{"INDUSTRY_DESC", "text"},
+		},
+		Key:    []string{"INDUSTRY_CODE"},
+		Unique: true,
+	},
+	{
+		Name:  "edm_sector_map.txt",
+		Table: "fsSectors",
+		Columns: []Column{
+			{"SECTOR_CODE", "char(4)"},
+			{"SECTOR_DESC", "text"},
+		},
+		Key:    []string{"SECTOR_CODE"},
+		Unique: true,
+	},
+	{
+		Name:  "edm_security_entity_map.txt",
+		Table: "fsSecurityEntities",
+		Columns: []Column{
+			{"FSYM_ID", "text"},
+			{"FACTSET_ENTITY_ID", "char(8)"},
+		},
+		Key:    []string{"FSYM_ID"},
+		Unique: true,
+	},
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// columnType matches the column types that fields can be converted to when
+// they are loaded, being those fsimporter's converterFor handles.
+var columnType = regexp.MustCompile(`(?i)^(text|(char|character|varchar|character varying)\s*(\(\d+\))?|smallint|int|integer|bigint|date|timestamp( without time zone)?)$`)
+
+// LoadFiles reads file mappings from a JSON array. An entry for a file that
+// is already in Files replaces it; others are added.
+func LoadFiles(path string) ([]File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var extra []File
+	if err := json.NewDecoder(f).Decode(&extra); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	files := append([]File(nil), Files...)
+	for _, e := range extra {
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		replaced := false
+		for i := range files {
+			if files[i].Name == e.Name {
+				files[i], replaced = e, true
+			}
+		}
+		if !replaced {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+func (f File) validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("mapping for table %s has no file", f.Table)
+	}
+	if !identifier.MatchString(f.Table) {
+		return fmt.Errorf("%s: invalid table name %q", f.Name, f.Table)
+	}
+	if f.Index != "" && !identifier.MatchString(f.Index) {
+		return fmt.Errorf("%s: invalid index name %q", f.Name, f.Index)
+	}
+	if len(f.Key) == 0 {
+		return fmt.Errorf("%s: no key columns", f.Name)
+	}
+	has := make(map[string]bool)
+	for _, col := range f.Columns {
+		if !identifier.MatchString(col.Name) {
+			return fmt.Errorf("%s: invalid column name %q", f.Name, col.Name)
+		}
+		if !columnType.MatchString(col.Type) {
+			return fmt.Errorf("%s: column %s has type %q, which cannot be loaded. use text, char(n), varchar(n), smallint, integer, bigint, date or timestamp", f.Name, col.Name, col.Type)
+		}
+		has[strings.ToUpper(col.Name)] = true
+	}
+	for _, key := range f.Key {
+		if !has[strings.ToUpper(key)] {
+			return fmt.Errorf("%s: key column %s is not one of its columns", f.Name, key)
+		}
+	}
+	return nil
+}
+
+func (f File) isKey(col string) bool {
+	for _, key := range f.Key {
+		if strings.EqualFold(key, col) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateStmt returns the statement that creates the file's table if it does
+// not already exist. Key columns are NOT NULL.
+func (f File) CreateStmt() string {
+	cols := make([]string, len(f.Columns))
+	for i, col := range f.Columns {
+		cols[i] = fmt.Sprintf("\t%s %s", col.Name, col.Type)
+		if f.isKey(col.Name) {
+			cols[i] += " NOT NULL"
+		}
+	}
+	return fmt.Sprintf("\nCREATE TABLE IF NOT EXISTS %s (\n%s\n);", f.Table, strings.Join(cols, ",\n"))
+}
+
+// KeyIndex returns the index on the file's key.
+func (f File) KeyIndex() Index {
+	name := f.Index
+	if name == "" {
+		name = f.Table + "_key"
+	}
+	return Index{name, f.Table, strings.Join(f.Key, ", "), f.Unique}
+}
@@ -0,0 +1,326 @@
+package factset
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration changes the FactSet schema from the previous version to
+// Version, or back again.
+type Migration struct {
+	Version     int
+	Description string
+	Up          []string
+	Down        []string
+}
+
+// Migrations are applied in order to the schema a database connection's
+// search_path points at. Version n is Migrations[n-1].
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: []string{
+			`
+CREATE TABLE fsEntity (
+	FACTSET_ENTITY_ID  varchar(255),
+	ENTITY_NAME        varchar(255),
+	ENTITY_PROPER_NAME varchar(255),
+	PRIMARY_SIC_CODE   varchar(255),
+	INDUSTRY_CODE      varchar(255),
+	SECTOR_CODE        varchar(255),
+	ISO_COUNTRY        varchar(255),
+	METRO_AREA         varchar(255),
+	STATE_PROVINCE     varchar(255),
+	ZIP_POSTAL_CODE    varchar(255),
+	WEB_SITE           varchar(255),
+	ENTITY_TYPE        varchar(255),
+	ENTITY_SUB_TYPE    varchar(255),
+	YEAR_FOUNDED       varchar(255),
+	ISO_COUNTRY_INCORP varchar(255),
+	ISO_COUNTRY_COR    varchar(255),
+	NACE_CODE          varchar(255)
+);`,
+			`
+CREATE TABLE fsStructure (
+	FACTSET_ENTITY_ID                 varchar(255),
+	FACTSET_PARENT_ENTITY_ID          varchar(255),
+	FACTSET_ULTIMATE_PARENT_ENTITY_ID varchar(255)
+);`,
+			`
+CREATE TABLE fsNames (
+	FACTSET_ENTITY_ID varchar(255),
+	ENTITY_NAME_TYPE  varchar(255),
+	ENTITY_NAME_VALUE varchar(255)
+);`,
+			`
+CREATE TABLE fsChanges (
+	FACTSET_ENTITY_ID varchar(255),
+	CHANGE_TYPE       varchar(255),
+	CHANGE_DATE       varchar(255),
+	OLD_VALUE         varchar(255),
+	NEW_VALUE         varchar(255),
+	AUDIT_TYPE        varchar(255),
+	COMMENTS          varchar(255),
+	AUDIT_ID          varchar(255)
+);`,
+			`
+CREATE TABLE fsIdentifiers (
+	FACTSET_ENTITY_ID varchar(255),
+	ENTITY_ID_TYPE    varchar(255),
+	ENTITY_ID_VALUE   varchar(255)
+);`,
+			`
+CREATE TABLE uuid_to_fsid (
+	UUID              varchar(255),
+	FACTSET_ENTITY_ID varchar(255)
+);`,
+		},
+		Down: []string{
+			`DROP TABLE uuid_to_fsid;`,
+			`DROP TABLE fsIdentifiers;`,
+			`DROP TABLE fsChanges;`,
+			`DROP TABLE fsNames;`,
+			`DROP TABLE fsStructure;`,
+			`DROP TABLE fsEntity;`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "typed columns, with NULL for missing values",
+		Up: []string{
+			`
+ALTER TABLE fsEntity
+	ALTER COLUMN FACTSET_ENTITY_ID  TYPE char(8) USING FACTSET_ENTITY_ID,
+	ALTER COLUMN FACTSET_ENTITY_ID  SET NOT NULL,
+	ALTER COLUMN ENTITY_NAME        TYPE text USING NULLIF(ENTITY_NAME, ''),
+	ALTER COLUMN ENTITY_PROPER_NAME TYPE text USING NULLIF(ENTITY_PROPER_NAME, ''),
+	ALTER COLUMN PRIMARY_SIC_CODE   TYPE char(4) USING NULLIF(PRIMARY_SIC_CODE, ''),
+	ALTER COLUMN INDUSTRY_CODE      TYPE char(4) USING NULLIF(INDUSTRY_CODE, ''),
+	ALTER COLUMN SECTOR_CODE        TYPE char(4) USING NULLIF(SECTOR_CODE, ''),
+	ALTER COLUMN ISO_COUNTRY        TYPE char(2) USING NULLIF(ISO_COUNTRY, ''),
+	ALTER COLUMN METRO_AREA         TYPE text USING NULLIF(METRO_AREA, ''),
+	ALTER COLUMN STATE_PROVINCE     TYPE text USING NULLIF(STATE_PROVINCE, ''),
+	ALTER COLUMN ZIP_POSTAL_CODE    TYPE text USING NULLIF(ZIP_POSTAL_CODE, ''),
+	ALTER COLUMN WEB_SITE           TYPE text USING NULLIF(WEB_SITE, ''),
+	ALTER COLUMN ENTITY_TYPE        TYPE char(3) USING NULLIF(ENTITY_TYPE, ''),
+	ALTER COLUMN ENTITY_SUB_TYPE    TYPE char(2) USING NULLIF(ENTITY_SUB_TYPE, ''),
+	ALTER COLUMN YEAR_FOUNDED       TYPE integer USING NULLIF(YEAR_FOUNDED, '')::integer,
+	ALTER COLUMN ISO_COUNTRY_INCORP TYPE char(2) USING NULLIF(ISO_COUNTRY_INCORP, ''),
+	ALTER COLUMN ISO_COUNTRY_COR    TYPE char(2) USING NULLIF(ISO_COUNTRY_COR, ''),
+	ALTER COLUMN NACE_CODE          TYPE text USING NULLIF(NACE_CODE, '');`,
+			`
+ALTER TABLE fsStructure
+	ALTER COLUMN FACTSET_ENTITY_ID                 TYPE char(8) USING FACTSET_ENTITY_ID,
+	ALTER COLUMN FACTSET_ENTITY_ID                 SET NOT NULL,
+	ALTER COLUMN FACTSET_PARENT_ENTITY_ID          TYPE char(8) USING NULLIF(FACTSET_PARENT_ENTITY_ID, ''),
+	ALTER COLUMN FACTSET_ULTIMATE_PARENT_ENTITY_ID TYPE char(8) USING NULLIF(FACTSET_ULTIMATE_PARENT_ENTITY_ID, '');`,
+			`
+ALTER TABLE fsNames
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE char(8) USING FACTSET_ENTITY_ID,
+	ALTER COLUMN FACTSET_ENTITY_ID SET NOT NULL,
+	ALTER COLUMN ENTITY_NAME_TYPE  TYPE text USING NULLIF(ENTITY_NAME_TYPE, ''),
+	ALTER COLUMN ENTITY_NAME_VALUE TYPE text USING NULLIF(ENTITY_NAME_VALUE, '');`,
+			`
+ALTER TABLE fsChanges
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE char(8) USING FACTSET_ENTITY_ID,
+	ALTER COLUMN FACTSET_ENTITY_ID SET NOT NULL,
+	ALTER COLUMN CHANGE_TYPE       TYPE text USING NULLIF(CHANGE_TYPE, ''),
+	ALTER COLUMN CHANGE_DATE       TYPE timestamp USING NULLIF(CHANGE_DATE, '')::timestamp,
+	ALTER COLUMN OLD_VALUE         TYPE text USING NULLIF(OLD_VALUE, ''),
+	ALTER COLUMN NEW_VALUE         TYPE text USING NULLIF(NEW_VALUE, ''),
+	ALTER COLUMN AUDIT_TYPE        TYPE text USING NULLIF(AUDIT_TYPE, ''),
+	ALTER COLUMN COMMENTS          TYPE text USING NULLIF(COMMENTS, ''),
+	ALTER COLUMN AUDIT_ID          TYPE text USING NULLIF(AUDIT_ID, '');`,
+			`
+ALTER TABLE fsIdentifiers
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE char(8) USING FACTSET_ENTITY_ID,
+	ALTER COLUMN FACTSET_ENTITY_ID SET NOT NULL,
+	ALTER COLUMN ENTITY_ID_TYPE    TYPE text USING NULLIF(ENTITY_ID_TYPE, ''),
+	ALTER COLUMN ENTITY_ID_VALUE   TYPE text USING NULLIF(ENTITY_ID_VALUE, '');`,
+			`
+ALTER TABLE uuid_to_fsid
+	ALTER COLUMN UUID              TYPE char(36) USING UUID,
+	ALTER COLUMN UUID              SET NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE char(8) USING FACTSET_ENTITY_ID,
+	ALTER COLUMN FACTSET_ENTITY_ID SET NOT NULL;`,
+		},
+		Down: []string{
+			`
+ALTER TABLE fsEntity
+	ALTER COLUMN FACTSET_ENTITY_ID  DROP NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID  TYPE varchar(255),
+	ALTER COLUMN ENTITY_NAME        TYPE varchar(255) USING COALESCE(ENTITY_NAME, ''),
+	ALTER COLUMN ENTITY_PROPER_NAME TYPE varchar(255) USING COALESCE(ENTITY_PROPER_NAME, ''),
+	ALTER COLUMN PRIMARY_SIC_CODE   TYPE varchar(255) USING COALESCE(PRIMARY_SIC_CODE, ''),
+	ALTER COLUMN INDUSTRY_CODE      TYPE varchar(255) USING COALESCE(INDUSTRY_CODE, ''),
+	ALTER COLUMN SECTOR_CODE        TYPE varchar(255) USING COALESCE(SECTOR_CODE, ''),
+	ALTER COLUMN ISO_COUNTRY        TYPE varchar(255) USING COALESCE(ISO_COUNTRY, ''),
+	ALTER COLUMN METRO_AREA         TYPE varchar(255) USING COALESCE(METRO_AREA, ''),
+	ALTER COLUMN STATE_PROVINCE     TYPE varchar(255) USING COALESCE(STATE_PROVINCE, ''),
+	ALTER COLUMN ZIP_POSTAL_CODE    TYPE varchar(255) USING COALESCE(ZIP_POSTAL_CODE, ''),
+	ALTER COLUMN WEB_SITE           TYPE varchar(255) USING COALESCE(WEB_SITE, ''),
+	ALTER COLUMN ENTITY_TYPE        TYPE varchar(255) USING COALESCE(ENTITY_TYPE, ''),
+	ALTER COLUMN ENTITY_SUB_TYPE    TYPE varchar(255) USING COALESCE(ENTITY_SUB_TYPE, ''),
+	ALTER COLUMN YEAR_FOUNDED       TYPE varchar(255) USING COALESCE(YEAR_FOUNDED::text, ''),
+	ALTER COLUMN ISO_COUNTRY_INCORP TYPE varchar(255) USING COALESCE(ISO_COUNTRY_INCORP, ''),
+	ALTER COLUMN ISO_COUNTRY_COR    TYPE varchar(255) USING COALESCE(ISO_COUNTRY_COR, ''),
+	ALTER COLUMN NACE_CODE          TYPE varchar(255) USING COALESCE(NACE_CODE, '');`,
+			`
+ALTER TABLE fsStructure
+	ALTER COLUMN FACTSET_ENTITY_ID                 DROP NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID                 TYPE varchar(255),
+	ALTER COLUMN FACTSET_PARENT_ENTITY_ID          TYPE varchar(255) USING COALESCE(FACTSET_PARENT_ENTITY_ID, ''),
+	ALTER COLUMN FACTSET_ULTIMATE_PARENT_ENTITY_ID TYPE varchar(255) USING COALESCE(FACTSET_ULTIMATE_PARENT_ENTITY_ID, '');`,
+			`
+ALTER TABLE fsNames
+	ALTER COLUMN FACTSET_ENTITY_ID DROP NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE varchar(255),
+	ALTER COLUMN ENTITY_NAME_TYPE  TYPE varchar(255) USING COALESCE(ENTITY_NAME_TYPE, ''),
+	ALTER COLUMN ENTITY_NAME_VALUE TYPE varchar(255) USING COALESCE(ENTITY_NAME_VALUE, '');`,
+			`
+ALTER TABLE fsChanges
+	ALTER COLUMN FACTSET_ENTITY_ID DROP NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE varchar(255),
+	ALTER COLUMN CHANGE_TYPE       TYPE varchar(255) USING COALESCE(CHANGE_TYPE, ''),
+	ALTER COLUMN CHANGE_DATE       TYPE varchar(255) USING COALESCE(CHANGE_DATE::text, ''),
+	ALTER COLUMN OLD_VALUE         TYPE varchar(255) USING COALESCE(OLD_VALUE, ''),
+	ALTER COLUMN NEW_VALUE         TYPE varchar(255) USING COALESCE(NEW_VALUE, ''),
+	ALTER COLUMN AUDIT_TYPE        TYPE varchar(255) USING COALESCE(AUDIT_TYPE, ''),
+	ALTER COLUMN COMMENTS          TYPE varchar(255) USING COALESCE(COMMENTS, ''),
+	ALTER COLUMN AUDIT_ID          TYPE varchar(255) USING COALESCE(AUDIT_ID, '');`,
+			`
+ALTER TABLE fsIdentifiers
+	ALTER COLUMN FACTSET_ENTITY_ID DROP NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE varchar(255),
+	ALTER COLUMN ENTITY_ID_TYPE    TYPE varchar(255) USING COALESCE(ENTITY_ID_TYPE, ''),
+	ALTER COLUMN ENTITY_ID_VALUE   TYPE varchar(255) USING COALESCE(ENTITY_ID_VALUE, '');`,
+			`
+ALTER TABLE uuid_to_fsid
+	ALTER COLUMN UUID              DROP NOT NULL,
+	ALTER COLUMN UUID              TYPE varchar(255),
+	ALTER COLUMN FACTSET_ENTITY_ID DROP NOT NULL,
+	ALTER COLUMN FACTSET_ENTITY_ID TYPE varchar(255);`,
+		},
+	},
+}
+
+// LatestVersion is the version of the schema once every migration has
+// been applied.
+func LatestVersion() int {
+	return len(Migrations)
+}
+
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	VERSION     integer PRIMARY KEY,
+	DESCRIPTION text NOT NULL,
+	APPLIED_AT  timestamp NOT NULL
+);`
+
+// Queryer is satisfied by both *sql.DB and *sql.Tx.
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// prepare creates the schema_version table if needed. A schema that already
+// has the FactSet tables but no recorded version predates migrations and is
+// taken to be at version 1.
+func prepare(q Queryer) error {
+	if _, err := q.Exec(schemaVersionTable); err != nil {
+		return err
+	}
+
+	var recorded, legacy bool
+	err := q.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM schema_version),
+	EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'fsentity');`).Scan(&recorded, &legacy)
+	if err != nil || recorded || !legacy {
+		return err
+	}
+	_, err = q.Exec("INSERT INTO schema_version VALUES ($1, $2, $3);", 1, Migrations[0].Description, time.Now().UTC())
+	return err
+}
+
+// Applied returns when each applied migration was applied, by version.
+func Applied(q Queryer) (map[int]time.Time, error) {
+	if err := prepare(q); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query("SELECT VERSION, APPLIED_AT FROM schema_version;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// SchemaVersion returns the version of the schema, 0 if it is empty.
+func SchemaVersion(q Queryer) (int, error) {
+	if err := prepare(q); err != nil {
+		return 0, err
+	}
+	var version int
+	err := q.QueryRow("SELECT COALESCE(MAX(VERSION), 0) FROM schema_version;").Scan(&version)
+	return version, err
+}
+
+// Migrate applies or reverts migrations until the schema is at the target
+// version. Nothing is changed unless the caller commits tx, so a failed
+// migration leaves the schema as it was.
+func Migrate(tx *sql.Tx, target int) error {
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("no schema version %d. the latest is %d", target, LatestVersion())
+	}
+
+	current, err := SchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	for ; current < target; current++ {
+		m := Migrations[current]
+		if err := execAll(tx, m.Up); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version VALUES ($1, $2, $3);", m.Version, m.Description, time.Now().UTC()); err != nil {
+			return err
+		}
+		log.Printf("applied migration %d: %s\n", m.Version, m.Description)
+	}
+	for ; current > target; current-- {
+		m := Migrations[current-1]
+		if err := execAll(tx, m.Down); err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		if _, err := tx.Exec("DELETE FROM schema_version WHERE VERSION = $1;", m.Version); err != nil {
+			return err
+		}
+		log.Printf("reverted migration %d: %s\n", m.Version, m.Description)
+	}
+	return nil
+}
+
+func execAll(tx *sql.Tx, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
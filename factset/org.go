@@ -0,0 +1,166 @@
+package factset
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Org is an organisation as the transformer serves it, built from an entity
+// and its related rows.
+type Org struct {
+	UUID                   string                 `json:"uuid"`
+	Type                   string                 `json:"type"`
+	ProperName             string                 `json:"properName"`
+	PrefLabel              string                 `json:"prefLabel"`
+	LegalName              string                 `json:"legalName,omitempty"`
+	ShortName              string                 `json:"shortName,omitempty"`
+	HiddenLabel            string                 `json:"hiddenLabel,omitempty"`
+	TradeNames             []string               `json:"tradeNames,omitempty"`
+	LocalNames             []string               `json:"localNames,omitempty"`
+	FormerNames            []string               `json:"formerNames,omitempty"`
+	Aliases                []string               `json:"aliases,omitempty"`
+	IndustryClassification string                 `json:"industryClassification,omitempty"`
+	ParentOrganisation     string                 `json:"parentOrganisation,omitempty"`
+	AlternativeIdentifiers AlternativeIdentifiers `json:"alternativeIdentifiers,omitempty"`
+	PostalCode             string                 `json:"postalCode,omitempty"`
+	CountryCode            string                 `json:"countryCode,omitempty"`
+	CountryOfIncorporation string                 `json:"countryOfIncorporation,omitempty"`
+	YearFounded            string                 `json:"yearFounded,omitempty"`
+}
+
+// AlternativeIdentifiers are the other identifiers an org is known by.
+type AlternativeIdentifiers struct {
+	TME               []string `json:"TME,omitempty"`
+	UUIDs             []string `json:"uuids,omitempty"`
+	FactsetIdentifier string   `json:"factsetIdentifier,omitempty"`
+	LeiCode           string   `json:"leiCode,omitempty"`
+}
+
+// OrgSelect fetches entities and everything their orgs are built from in a
+// single round trip, with each one's names and identifiers aggregated as
+// JSON. Callers add the WHERE clause, and read the rows with ScanOrg.
+const OrgSelect = `
+SELECT u.UUID, e.FACTSET_ENTITY_ID, e.ENTITY_NAME, e.ENTITY_PROPER_NAME, e.INDUSTRY_CODE, e.ISO_COUNTRY,
+	e.ZIP_POSTAL_CODE, e.ENTITY_TYPE, e.YEAR_FOUNDED, e.ISO_COUNTRY_INCORP, s.FACTSET_PARENT_ENTITY_ID,
+	(SELECT json_agg(json_build_object('type', n.ENTITY_NAME_TYPE, 'value', n.ENTITY_NAME_VALUE) ORDER BY n.ENTITY_NAME_TYPE, n.ENTITY_NAME_VALUE)
+		FROM fsNames n WHERE n.FACTSET_ENTITY_ID = e.FACTSET_ENTITY_ID),
+	(SELECT json_agg(json_build_object('type', i.ENTITY_ID_TYPE, 'value', i.ENTITY_ID_VALUE) ORDER BY i.ENTITY_ID_TYPE, i.ENTITY_ID_VALUE)
+		FROM fsIdentifiers i WHERE i.FACTSET_ENTITY_ID = e.FACTSET_ENTITY_ID)
+FROM uuid_to_fsid u
+JOIN fsEntity e ON e.FACTSET_ENTITY_ID = u.FACTSET_ENTITY_ID
+LEFT JOIN fsStructure s ON s.FACTSET_ENTITY_ID = e.FACTSET_ENTITY_ID`
+
+// TypedValue is a row of fsNames or fsIdentifiers as OrgSelect aggregates
+// them.
+type TypedValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Scanner is a row to be scanned, a *sql.Row or *sql.Rows.
+type Scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// ScanOrg builds an org from a row of OrgSelect.
+func ScanOrg(row Scanner) (o Org, err error) {
+	var u UUIDMapping
+	var e Entity
+	var s Structure
+	var names, idents []byte
+	err = row.Scan(
+		&u.UUID,
+		&e.FACTSET_ENTITY_ID,
+		&e.ENTITY_NAME,
+		&e.ENTITY_PROPER_NAME,
+		&e.INDUSTRY_CODE,
+		&e.ISO_COUNTRY,
+		&e.ZIP_POSTAL_CODE,
+		&e.ENTITY_TYPE,
+		&e.YEAR_FOUNDED,
+		&e.ISO_COUNTRY_INCORP,
+		&s.FACTSET_PARENT_ENTITY_ID,
+		&names,
+		&idents,
+	)
+	if err != nil {
+		return
+	}
+
+	var n, i []TypedValue
+	if names != nil {
+		if err = json.Unmarshal(names, &n); err != nil {
+			return
+		}
+	}
+	if idents != nil {
+		if err = json.Unmarshal(idents, &i); err != nil {
+			return
+		}
+	}
+	return BuildOrg(u, e, s, n, i), nil
+}
+
+// BuildOrg assembles an org from its entity's rows. Names and identifiers
+// of types that orgs have no place for are ignored.
+func BuildOrg(u UUIDMapping, e Entity, s Structure, names []TypedValue, idents []TypedValue) (o Org) {
+	o.UUID = u.UUID
+	switch e.ENTITY_TYPE.String {
+	case "PUB":
+		o.Type = "PublicCompany"
+	case "EXT":
+		//o.Extinct = true
+		o.Type = "Organisation"
+	default:
+		o.Type = "Organisation"
+	}
+
+	o.PrefLabel = e.ENTITY_PROPER_NAME.String
+	o.ProperName = e.ENTITY_PROPER_NAME.String
+	o.HiddenLabel = e.ENTITY_NAME.String
+
+	if e.YEAR_FOUNDED.Valid {
+		o.YearFounded = strconv.FormatInt(e.YEAR_FOUNDED.Int64, 10)
+	}
+
+	o.AlternativeIdentifiers = AlternativeIdentifiers{
+		FactsetIdentifier: e.FACTSET_ENTITY_ID,
+		UUIDs:             []string{u.UUID},
+	}
+
+	if e.INDUSTRY_CODE.String != "" {
+		o.IndustryClassification = ICFromFsIc(e.INDUSTRY_CODE.String)
+	}
+
+	o.PostalCode = e.ZIP_POSTAL_CODE.String
+	o.CountryCode = e.ISO_COUNTRY.String
+	o.CountryOfIncorporation = e.ISO_COUNTRY_INCORP.String
+
+	if s.FACTSET_PARENT_ENTITY_ID.String != "" {
+		o.ParentOrganisation = UUIDFromFsid(s.FACTSET_PARENT_ENTITY_ID.String)
+	}
+
+	for _, nr := range names {
+		switch nr.Type {
+		case "FORMER_NAME":
+			o.FormerNames = append(o.FormerNames, nr.Value)
+		case "SHORT_NAME":
+			o.ShortName = nr.Value
+		case "LEGAL_NAME":
+			o.LegalName = nr.Value
+		case "TRADE_DBA_NAME":
+			o.TradeNames = append(o.TradeNames, nr.Value)
+		case "LOCAL_NAME":
+			o.LocalNames = append(o.LocalNames, nr.Value)
+		}
+	}
+
+	for _, ident := range idents {
+		switch ident.Type {
+		case "LEI":
+			o.AlternativeIdentifiers.LeiCode = ident.Value
+		}
+	}
+
+	return
+}
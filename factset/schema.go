@@ -0,0 +1,40 @@
+package factset
+
+import "fmt"
+
+// Index describes an index on one of the FactSet tables.
+type Index struct {
+	Name    string
+	Table   string
+	Columns string
+	Unique  bool
+}
+
+// Indexes returns the indexes on the tables of files, on uuid_to_fsid, and
+// on fsIdentifiers' values, by which orgs are looked up. They are built once
+// a full import has loaded its rows, and added to existing schemas by
+// fsimporter migrate up.
+func Indexes(files []File) []Index {
+	indexes := make([]Index, 0, len(files)+2)
+	for _, f := range files {
+		indexes = append(indexes, f.KeyIndex())
+	}
+	return append(indexes,
+		Index{"uuid_uuid", "uuid_to_fsid", "UUID", false},
+		Index{"fsIdentifiers_value", "fsIdentifiers", "ENTITY_ID_TYPE, ENTITY_ID_VALUE", false},
+	)
+}
+
+// CreateStmt returns the statement that builds the index if it does not
+// already exist.
+func (i Index) CreateStmt(concurrently bool) string {
+	stmt := "CREATE "
+	if i.Unique {
+		stmt += "UNIQUE "
+	}
+	stmt += "INDEX "
+	if concurrently {
+		stmt += "CONCURRENTLY "
+	}
+	return stmt + fmt.Sprintf("IF NOT EXISTS %s ON %s (%s);", i.Name, i.Table, i.Columns)
+}
@@ -0,0 +1,68 @@
+package factset
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// UUIDMapping is a row of uuid_to_fsid.
+type UUIDMapping struct {
+	UUID              string
+	FACTSET_ENTITY_ID string
+}
+
+// Entity is a row of fsEntity, loaded from edm_entity.txt.
+type Entity struct {
+	FACTSET_ENTITY_ID  string
+	ENTITY_NAME        sql.NullString
+	ENTITY_PROPER_NAME sql.NullString
+	PRIMARY_SIC_CODE   sql.NullString
+	INDUSTRY_CODE      sql.NullString
+	SECTOR_CODE        sql.NullString
+	ISO_COUNTRY        sql.NullString
+	METRO_AREA         sql.NullString
+	STATE_PROVINCE     sql.NullString
+	ZIP_POSTAL_CODE    sql.NullString
+	WEB_SITE           sql.NullString
+	ENTITY_TYPE        sql.NullString
+	ENTITY_SUB_TYPE    sql.NullString
+	YEAR_FOUNDED       sql.NullInt64
+	ISO_COUNTRY_INCORP sql.NullString
+	ISO_COUNTRY_COR    sql.NullString
+	NACE_CODE          sql.NullString
+}
+
+// Structure is a row of fsStructure, loaded from edm_entity_structure.txt.
+type Structure struct {
+	FACTSET_ENTITY_ID                 string
+	FACTSET_PARENT_ENTITY_ID          sql.NullString
+	FACTSET_ULTIMATE_PARENT_ENTITY_ID sql.NullString
+}
+
+// Name is a row of fsNames, loaded from edm_entity_names.txt.
+type Name struct {
+	FACTSET_ENTITY_ID string
+	ENTITY_NAME_TYPE  sql.NullString
+	ENTITY_NAME_VALUE sql.NullString
+}
+
+// Change is a row of fsChanges, loaded from edm_entity_changes.txt.
+type Change struct {
+	FACTSET_ENTITY_ID string
+	CHANGE_TYPE       sql.NullString
+	CHANGE_DATE       pq.NullTime
+	OLD_VALUE         sql.NullString
+	NEW_VALUE         sql.NullString
+	AUDIT_TYPE        sql.NullString
+	COMMENTS          sql.NullString
+	AUDIT_ID          sql.NullString
+}
+
+// Identifier is a row of fsIdentifiers, loaded from
+// edm_entity_identifiers.txt.
+type Identifier struct {
+	FACTSET_ENTITY_ID string
+	ENTITY_ID_TYPE    sql.NullString
+	ENTITY_ID_VALUE   sql.NullString
+}
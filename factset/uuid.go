@@ -0,0 +1,26 @@
+// Package factset holds what the FactSet tools share: the types of the rows
+// loaded from the EDM files, the canonical schema they are loaded into, the
+// derivation of UUIDs from FactSet identifiers and the assembly of orgs from
+// the rows.
+package factset
+
+import (
+	"crypto/md5"
+
+	"github.com/pborman/uuid"
+)
+
+var emptyUUID = uuid.UUID{}
+
+// UUIDFromFsid derives the UUID of an organisation from its
+// FACTSET_ENTITY_ID.
+func UUIDFromFsid(fsid string) string {
+	md5data := md5.Sum([]byte(fsid))
+	return uuid.NewHash(md5.New(), emptyUUID, md5data[:], 3).String()
+}
+
+// ICFromFsIc derives the UUID of an industry classification from its
+// FactSet INDUSTRY_CODE.
+func ICFromFsIc(fsic string) string {
+	return uuid.NewHash(md5.New(), emptyUUID, []byte(fsic), 3).String()
+}
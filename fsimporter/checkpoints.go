@@ -0,0 +1,48 @@
+package main
+
+import "database/sql"
+
+// checkpointsTable records, per zip entry, how many rows have been committed
+// so that an interrupted load can be resumed.
+const checkpointsTable = `
+CREATE TABLE IF NOT EXISTS import_checkpoints (
+	FILE_NAME      varchar(255) PRIMARY KEY,
+	SOURCE         varchar(255) NOT NULL,
+	ROWS_COMMITTED bigint NOT NULL,
+	COMPLETE       boolean NOT NULL
+);`
+
+type checkpoint struct {
+	file     string
+	source   string
+	rows     int64
+	complete bool
+}
+
+func loadCheckpoints(db *sql.DB) (map[string]checkpoint, error) {
+	rows, err := db.Query("SELECT FILE_NAME, SOURCE, ROWS_COMMITTED, COMPLETE FROM import_checkpoints;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]checkpoint)
+	for rows.Next() {
+		var cp checkpoint
+		if err := rows.Scan(&cp.file, &cp.source, &cp.rows, &cp.complete); err != nil {
+			return nil, err
+		}
+		checkpoints[cp.file] = cp
+	}
+	return checkpoints, rows.Err()
+}
+
+// saveCheckpoint records cp as part of tx, so that it is committed together
+// with the rows it counts.
+func saveCheckpoint(tx *sql.Tx, cp checkpoint) error {
+	_, err := tx.Exec(`
+INSERT INTO import_checkpoints (FILE_NAME, SOURCE, ROWS_COMMITTED, COMPLETE) VALUES ($1, $2, $3, $4)
+ON CONFLICT (FILE_NAME) DO UPDATE SET ROWS_COMMITTED = EXCLUDED.ROWS_COMMITTED, COMPLETE = EXCLUDED.COMPLETE;`,
+		cp.file, cp.source, cp.rows, cp.complete)
+	return err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// columnMap maps the fields of a FactSet file onto the columns of its table
+// by name, so that columns added to or reordered in the file are handled.
+// Table columns that the file does not have are left NULL.
+type columnMap struct {
+	table    string
+	columns  []string    // the table columns written
+	fields   []int       // the index in each row of the value for each column
+	converts []converter // converts each field to its column's type
+	key      []string    // the key columns
+	keys     []int       // the index in each row of the value for each key column
+}
+
+// column describes a table column as reported by information_schema.
+type column struct {
+	name     string
+	dataType string
+	maxLen   sql.NullInt64
+}
+
+// mapColumns matches the header of a file against the columns of ft's
+// table. Header columns that the table lacks are added to it if addColumns
+// is set, and otherwise ignored. Every key column must be in the header.
+func mapColumns(db factset.Queryer, file string, ft factset.File, header []string, addColumns bool) (columnMap, error) {
+	existing, err := tableColumns(db, ft.Table)
+	if err != nil {
+		return columnMap{}, err
+	}
+	if len(existing) == 0 {
+		return columnMap{}, fmt.Errorf("table %s does not exist", ft.Table)
+	}
+
+	var add func(name string) error
+	if addColumns {
+		add = func(name string) error {
+			_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s text;", ft.Table, name))
+			return err
+		}
+	}
+	return matchColumns(file, ft, existing, header, add)
+}
+
+// matchColumns matches header against the existing columns of ft's table.
+// Header columns that the table lacks are added with add, unless it is nil.
+func matchColumns(file string, ft factset.File, existing []column, header []string, add func(name string) error) (columnMap, error) {
+	table := ft.Table
+	m := columnMap{table: table}
+
+	if err := m.mapKey(ft, header); err != nil {
+		return m, err
+	}
+
+	has := make(map[string]column)
+	for _, col := range existing {
+		has[col.name] = col
+	}
+
+	mapped := make(map[string]bool)
+	for i, name := range header {
+		col := strings.ToLower(name)
+		if mapped[col] {
+			return m, fmt.Errorf("column %s appears more than once", name)
+		}
+		c, ok := has[col]
+		if !ok {
+			if add == nil {
+				log.Printf("%s: ignoring column %s, which %s does not have\n", file, name, table)
+				continue
+			}
+			if !identifier.MatchString(name) {
+				return m, fmt.Errorf("cannot add column %q to %s", name, table)
+			}
+			if err := add(name); err != nil {
+				return m, err
+			}
+			log.Printf("%s: added column %s to %s\n", file, name, table)
+			c = column{name: col, dataType: "text"}
+		}
+		convert, err := converterFor(c)
+		if err != nil {
+			return m, err
+		}
+		m.columns = append(m.columns, col)
+		m.fields = append(m.fields, i)
+		m.converts = append(m.converts, convert)
+		mapped[col] = true
+	}
+
+	for _, col := range existing {
+		if !mapped[col.name] {
+			log.Printf("%s: no %s column. it will be left NULL in %s\n", file, col.name, table)
+		}
+	}
+
+	if len(m.columns) == 0 {
+		return m, fmt.Errorf("no columns in common with %s", table)
+	}
+	return m, nil
+}
+
+// tableColumns returns the columns of table in the current schema.
+func tableColumns(db factset.Queryer, table string) ([]column, error) {
+	rows, err := db.Query(`
+SELECT column_name, data_type, character_maximum_length FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = $1
+ORDER BY ordinal_position;`, strings.ToLower(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []column
+	for rows.Next() {
+		var col column
+		if err := rows.Scan(&col.name, &col.dataType, &col.maxLen); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// mapKey finds ft's key columns in header.
+func (m *columnMap) mapKey(ft factset.File, header []string) error {
+	for _, key := range ft.Key {
+		found := false
+		for i, name := range header {
+			if strings.EqualFold(name, key) {
+				m.key = append(m.key, strings.ToLower(key))
+				m.keys = append(m.keys, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no key column %s", key)
+		}
+	}
+	return nil
+}
+
+var declaredType = regexp.MustCompile(`^([a-z ]+?)\s*(?:\((\d+)\))?$`)
+
+// declaredColumns describes the columns of ft's table as its mapping
+// declares them, in information_schema's terms, for when there is no
+// database to ask.
+func declaredColumns(ft factset.File) ([]column, error) {
+	cols := make([]column, len(ft.Columns))
+	for i, c := range ft.Columns {
+		m := declaredType.FindStringSubmatch(strings.ToLower(c.Type))
+		if m == nil {
+			return nil, fmt.Errorf("%s: cannot understand type %q of %s", ft.Name, c.Type, c.Name)
+		}
+		col := column{name: strings.ToLower(c.Name), dataType: m[1]}
+		switch col.dataType {
+		case "char":
+			col.dataType = "character"
+		case "varchar":
+			col.dataType = "character varying"
+		case "int":
+			col.dataType = "integer"
+		case "timestamp":
+			col.dataType = "timestamp without time zone"
+		}
+		if m[2] != "" {
+			n, _ := strconv.ParseInt(m[2], 10, 64)
+			col.maxLen = sql.NullInt64{Int64: n, Valid: true}
+		}
+		cols[i] = col
+	}
+	return cols, nil
+}
+
+func (m columnMap) insertStmt() string {
+	params := make([]string, len(m.columns))
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES(%s);", m.table, strings.Join(m.columns, ", "), strings.Join(params, ", "))
+}
+
+func (m columnMap) copyStmt() string {
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN;", m.table, strings.Join(m.columns, ", "))
+}
+
+// deleteStmt deletes the rows with the key values given as parameters.
+func (m columnMap) deleteStmt() string {
+	conds := make([]string, len(m.key))
+	for i, col := range m.key {
+		conds[i] = fmt.Sprintf("%s = $%d", col, i+1)
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s;", m.table, strings.Join(conds, " AND "))
+}
+
+// keyValues picks the key values out of a row.
+func (m columnMap) keyValues(row []interface{}) []interface{} {
+	vals := make([]interface{}, len(m.keys))
+	for i, k := range m.keys {
+		vals[i] = row[k]
+	}
+	return vals
+}
+
+// values picks the values for the mapped columns out of a row and converts
+// them to the columns' types.
+func (m columnMap) values(row []interface{}) ([]interface{}, error) {
+	vals := make([]interface{}, len(m.fields))
+	for i, f := range m.fields {
+		val, err := m.converts[i](row[f].(string))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m.columns[i], err)
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
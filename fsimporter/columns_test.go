@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+var testFile = factset.File{
+	Name:  "edm_entity_names.txt",
+	Table: "fsNames",
+	Key:   []string{"FACTSET_ENTITY_ID"},
+}
+
+var testColumns = []column{
+	{name: "factset_entity_id", dataType: "character", maxLen: sql.NullInt64{Int64: 8, Valid: true}},
+	{name: "entity_name_type", dataType: "text"},
+	{name: "entity_name_value", dataType: "text"},
+}
+
+func TestMatchColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []string
+		row    []interface{}
+		// add is whether new columns are added rather than ignored
+		add     bool
+		columns []string
+		values  []interface{}
+		keys    []interface{}
+		added   []string
+		wantErr string
+	}{
+		{
+			name:    "in table order",
+			header:  []string{"FACTSET_ENTITY_ID", "ENTITY_NAME_TYPE", "ENTITY_NAME_VALUE"},
+			row:     []interface{}{"000C7F-E", "SHORT_NAME", "Foo"},
+			columns: []string{"factset_entity_id", "entity_name_type", "entity_name_value"},
+			values:  []interface{}{"000C7F-E", "SHORT_NAME", "Foo"},
+			keys:    []interface{}{"000C7F-E"},
+		},
+		{
+			name:    "reordered",
+			header:  []string{"entity_name_value", "FACTSET_ENTITY_ID", "Entity_Name_Type"},
+			row:     []interface{}{"Foo", "000C7F-E", "SHORT_NAME"},
+			columns: []string{"entity_name_value", "factset_entity_id", "entity_name_type"},
+			values:  []interface{}{"Foo", "000C7F-E", "SHORT_NAME"},
+			keys:    []interface{}{"000C7F-E"},
+		},
+		{
+			name:    "unknown column ignored",
+			header:  []string{"FACTSET_ENTITY_ID", "NEW_COLUMN", "ENTITY_NAME_TYPE", "ENTITY_NAME_VALUE"},
+			row:     []interface{}{"000C7F-E", "x", "SHORT_NAME", "Foo"},
+			columns: []string{"factset_entity_id", "entity_name_type", "entity_name_value"},
+			values:  []interface{}{"000C7F-E", "SHORT_NAME", "Foo"},
+			keys:    []interface{}{"000C7F-E"},
+		},
+		{
+			name:    "unknown column added",
+			header:  []string{"FACTSET_ENTITY_ID", "NEW_COLUMN", "ENTITY_NAME_TYPE", "ENTITY_NAME_VALUE"},
+			row:     []interface{}{"000C7F-E", "x", "SHORT_NAME", "Foo"},
+			add:     true,
+			columns: []string{"factset_entity_id", "new_column", "entity_name_type", "entity_name_value"},
+			values:  []interface{}{"000C7F-E", "x", "SHORT_NAME", "Foo"},
+			keys:    []interface{}{"000C7F-E"},
+			added:   []string{"NEW_COLUMN"},
+		},
+		{
+			name:    "unknown column that cannot be added",
+			header:  []string{"FACTSET_ENTITY_ID", "NEW COLUMN"},
+			add:     true,
+			wantErr: `cannot add column "NEW COLUMN"`,
+		},
+		{
+			name:    "missing column left NULL",
+			header:  []string{"FACTSET_ENTITY_ID", "ENTITY_NAME_VALUE"},
+			row:     []interface{}{"000C7F-E", ""},
+			columns: []string{"factset_entity_id", "entity_name_value"},
+			values:  []interface{}{"000C7F-E", nil},
+			keys:    []interface{}{"000C7F-E"},
+		},
+		{
+			name:    "duplicate column",
+			header:  []string{"FACTSET_ENTITY_ID", "ENTITY_NAME_TYPE", "entity_name_type"},
+			wantErr: "column entity_name_type appears more than once",
+		},
+		{
+			name:    "missing key column",
+			header:  []string{"ENTITY_NAME_TYPE", "ENTITY_NAME_VALUE"},
+			wantErr: "no key column FACTSET_ENTITY_ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var added []string
+			var add func(name string) error
+			if tt.add {
+				add = func(name string) error {
+					added = append(added, name)
+					return nil
+				}
+			}
+
+			m, err := matchColumns(testFile.Name, testFile, testColumns, tt.header, add)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(m.columns, tt.columns) {
+				t.Errorf("columns %v, want %v", m.columns, tt.columns)
+			}
+			vals, err := m.values(tt.row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(vals, tt.values) {
+				t.Errorf("values %v, want %v", vals, tt.values)
+			}
+			if keys := m.keyValues(tt.row); !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("keys %v, want %v", keys, tt.keys)
+			}
+			if !reflect.DeepEqual(added, tt.added) {
+				t.Errorf("added %v, want %v", added, tt.added)
+			}
+		})
+	}
+}
+
+func TestMatchColumnsAddFails(t *testing.T) {
+	add := func(name string) error { return errors.New("no permission") }
+	_, err := matchColumns(testFile.Name, testFile, testColumns, []string{"FACTSET_ENTITY_ID", "NEW_COLUMN"}, add)
+	if err == nil || err.Error() != "no permission" {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestMatchColumnsConvertsValues(t *testing.T) {
+	m, err := matchColumns(testFile.Name, testFile, testColumns, []string{"FACTSET_ENTITY_ID", "ENTITY_NAME_VALUE"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.values([]interface{}{"000C7F-EX", "Foo"})
+	if err == nil || !strings.HasPrefix(err.Error(), "factset_entity_id: ") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestDeclaredColumns(t *testing.T) {
+	ft := factset.File{
+		Name: "x.txt",
+		Columns: []factset.Column{
+			{Name: "A", Type: "char(8)"},
+			{Name: "B", Type: "VARCHAR (20)"},
+			{Name: "C", Type: "int"},
+			{Name: "D", Type: "timestamp"},
+			{Name: "E", Type: "text"},
+			{Name: "F", Type: "character varying(3)"},
+		},
+	}
+	cols, err := declaredColumns(ft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []column{
+		{name: "a", dataType: "character", maxLen: sql.NullInt64{Int64: 8, Valid: true}},
+		{name: "b", dataType: "character varying", maxLen: sql.NullInt64{Int64: 20, Valid: true}},
+		{name: "c", dataType: "integer"},
+		{name: "d", dataType: "timestamp without time zone"},
+		{name: "e", dataType: "text"},
+		{name: "f", dataType: "character varying", maxLen: sql.NullInt64{Int64: 3, Valid: true}},
+	}
+	if !reflect.DeepEqual(cols, want) {
+		t.Errorf("got %+v, want %+v", cols, want)
+	}
+
+	ft.Columns = []factset.Column{{Name: "A", Type: "numeric(10,2)"}}
+	if _, err := declaredColumns(ft); err == nil {
+		t.Error("numeric(10,2) was understood")
+	}
+}
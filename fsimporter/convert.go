@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// converter turns a field read from a FactSet file into a value for its
+// column. Empty fields become NULL.
+type converter func(string) (interface{}, error)
+
+var timestampLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"01/02/2006",
+}
+
+// converterFor returns the converter for values of col's type. Mappings are
+// limited to these types by factset.LoadFiles, so a type added here must be
+// allowed there too.
+func converterFor(col column) (converter, error) {
+	switch col.dataType {
+	case "text":
+		return convertText, nil
+	case "character", "character varying":
+		if !col.maxLen.Valid {
+			return convertText, nil
+		}
+		return convertChars(int(col.maxLen.Int64)), nil
+	case "smallint", "integer", "bigint":
+		return convertInt, nil
+	case "date", "timestamp without time zone":
+		return convertTimestamp, nil
+	}
+	return nil, fmt.Errorf("no conversion to %s for column %s", col.dataType, col.name)
+}
+
+func convertText(val string) (interface{}, error) {
+	if val == "" {
+		return nil, nil
+	}
+	return val, nil
+}
+
+func convertChars(max int) converter {
+	return func(val string) (interface{}, error) {
+		if val == "" {
+			return nil, nil
+		}
+		if n := utf8.RuneCountInString(val); n > max {
+			return nil, fmt.Errorf("%q is %d characters long, longer than %d", val, n, max)
+		}
+		return val, nil
+	}
+}
+
+func convertInt(val string) (interface{}, error) {
+	if val == "" {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an integer", val)
+	}
+	return i, nil
+}
+
+func convertTimestamp(val string) (interface{}, error) {
+	if val == "" {
+		return nil, nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a date", val)
+}
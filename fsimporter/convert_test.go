@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConverters(t *testing.T) {
+	date := func(y int, m time.Month, d, h, min, s int) time.Time {
+		return time.Date(y, m, d, h, min, s, 0, time.UTC)
+	}
+	char := func(n int64) column {
+		return column{name: "c", dataType: "character", maxLen: sql.NullInt64{Int64: n, Valid: true}}
+	}
+
+	tests := []struct {
+		name    string
+		col     column
+		in      string
+		want    interface{}
+		wantErr string
+	}{
+		{"text", column{dataType: "text"}, "Foo|Bar", "Foo|Bar", ""},
+		{"empty text", column{dataType: "text"}, "", nil, ""},
+		{"char", char(8), "000C7F-E", "000C7F-E", ""},
+		{"char counts runes", char(3), "Zoë", "Zoë", ""},
+		{"char overflow", char(2), "GBR", nil, `"GBR" is 3 characters long, longer than 2`},
+		{"empty char", char(2), "", nil, ""},
+		{"varchar without length", column{dataType: "character varying"}, "any length at all", "any length at all", ""},
+		{"integer", column{dataType: "integer"}, "1984", int64(1984), ""},
+		{"negative bigint", column{dataType: "bigint"}, "-9000000000", int64(-9000000000), ""},
+		{"smallint", column{dataType: "smallint"}, "7", int64(7), ""},
+		{"not an integer", column{dataType: "integer"}, "19.5", nil, `"19.5" is not an integer`},
+		{"empty integer", column{dataType: "integer"}, "", nil, ""},
+		{"date", column{dataType: "date"}, "2017-03-14", date(2017, 3, 14, 0, 0, 0), ""},
+		{"timestamp", column{dataType: "timestamp without time zone"}, "2017-03-14 09:26:53", date(2017, 3, 14, 9, 26, 53), ""},
+		{"iso timestamp", column{dataType: "timestamp without time zone"}, "2017-03-14T09:26:53", date(2017, 3, 14, 9, 26, 53), ""},
+		{"us date", column{dataType: "date"}, "03/14/2017", date(2017, 3, 14, 0, 0, 0), ""},
+		{"not a date", column{dataType: "date"}, "14/03/2017", nil, `"14/03/2017" is not a date`},
+		{"empty date", column{dataType: "date"}, "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			convert, err := converterFor(tt.col)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := convert(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoConverter(t *testing.T) {
+	for _, dataType := range []string{"numeric", "boolean", "timestamp with time zone", "jsonb"} {
+		_, err := converterFor(column{name: "c", dataType: dataType})
+		if err == nil || !strings.Contains(err.Error(), "no conversion to "+dataType) {
+			t.Errorf("%s: got error %v", dataType, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// cursorBatchSize is the number of rows cursorBatches fetches at a time.
+const cursorBatchSize = 10000
+
+// cursorBatches reads the rows of query through a server-side cursor in tx,
+// calling scan for each row of a batch and then flush, which is free to
+// write on tx, until there are no more. The cursor sees what tx has written
+// before it was declared, and nothing after. query cannot take parameters.
+func cursorBatches(tx *sql.Tx, name string, query string, scan func(rows *sql.Rows) error, flush func() error) error {
+	if _, err := tx.Exec(fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s;", name, query)); err != nil {
+		return err
+	}
+
+	for {
+		n, err := fetchBatch(tx, name, scan)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if n < cursorBatchSize {
+			break
+		}
+	}
+
+	_, err := tx.Exec(fmt.Sprintf("CLOSE %s;", name))
+	return err
+}
+
+func fetchBatch(tx *sql.Tx, name string, scan func(rows *sql.Rows) error) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf("FETCH %d FROM %s;", cursorBatchSize, name))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
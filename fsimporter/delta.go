@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+func deleteFileName(name string) string {
+	return strings.TrimSuffix(name, ".txt") + "_delete.txt"
+}
+
+// loadDelta applies a FactSet delta delivery (e.g.
+// edm_premium_delta_1618.zip) to an existing database. The delivery carries
+// the same file names as the full file, holding the complete current set of
+// rows for every key (usually a FACTSET_ENTITY_ID) that was added or
+// changed, plus a matching "_delete" entry (e.g. edm_entity_delete.txt)
+// listing the keys whose rows are to be removed.
+//
+// The whole delta, along with the uuid mapping and orgs of the entities it
+// changes, is applied in a single transaction, so readers never see it half
+// done and a delta that fails leaves nothing behind.
+func loadDelta(edm *edmFiles, db *sql.DB, opts loadOptions) error {
+	if err := opts.createTables(db); err != nil {
+		return err
+	}
+	for _, stmt := range []string{rejectsTable, orgsTable} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	files := make(map[string]edmFile)
+	for _, file := range edm.files {
+		files[file.name] = file
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p := startProgress("INSERT")
+	defer p.Stop()
+	touched := make(touchedEntities)
+
+	for _, ft := range opts.files {
+		name := ft.Name
+		upserts, hasUpserts := files[name]
+		deletes, hasDeletes := files[deleteFileName(name)]
+		delete(files, name)
+		delete(files, deleteFileName(name))
+
+		// deletes first, so that a key removed and re-added in the same
+		// delta survives.
+		if hasDeletes {
+			fp := p.file(deletes, ft.Table)
+			err := readDeltaDeletes(tx, deletes, ft, opts, fp, touched)
+			p.finish(fp, err)
+			if err != nil {
+				return fileError(deletes.name, err)
+			}
+		}
+		if hasUpserts {
+			fp := p.file(upserts, ft.Table)
+			err := readDeltaUpserts(tx, upserts, ft, opts, fp, touched)
+			p.finish(fp, err)
+			if err != nil {
+				return fileError(upserts.name, err)
+			}
+		}
+	}
+	for name := range files {
+		fmt.Fprintf(os.Stderr, "we have no use for %s\n", name)
+	}
+
+	log.Println("updating uuid mapping")
+	if _, err := tx.Exec(`DELETE FROM uuid_to_fsid u WHERE NOT EXISTS (SELECT 1 FROM fsEntity e WHERE e.FACTSET_ENTITY_ID = u.FACTSET_ENTITY_ID);`); err != nil {
+		return err
+	}
+	if err := mapUUIDs(tx); err != nil {
+		return err
+	}
+	log.Println("done uuid mapping")
+
+	// rebuild the orgs of the entities the delta changed
+	if err := materialiseOrgs(tx, touched.list(), false); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// readDeltaUpserts replaces, in tx, all rows in the table for each key
+// present in f with the rows given in f. The entities changed are added to
+// touched.
+func readDeltaUpserts(tx *sql.Tx, f edmFile, ft factset.File, opts loadOptions, fp *fileProgress, touched touchedEntities) error {
+	scanner, rc, err := openScanner(f, &fp.read, opts.encoding)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	cols, err := mapColumns(tx, f.name, ft, scanner.Header(), opts.addColumns)
+	if err != nil {
+		return err
+	}
+
+	fsidCol := headerIndex(scanner.Header(), "FACTSET_ENTITY_ID")
+
+	del, err := tx.Prepare(cols.deleteStmt())
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+	ins, err := tx.Prepare(cols.insertStmt())
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	d := &deltaRows{tx: tx, file: f.name, table: ft.Table, opts: opts}
+	seen := make(map[string]bool)
+	for scanner.Scan() {
+		err := d.apply(scanner, func(row []interface{}) error {
+			if fsidCol >= 0 {
+				touched.add(row[fsidCol].(string))
+			}
+			key := cols.keyValues(row)
+			k := fmt.Sprintf("%q", key)
+			if !seen[k] {
+				if _, err := del.Exec(key...); err != nil {
+					return err
+				}
+			}
+			vals, err := cols.values(row)
+			if err != nil {
+				return err
+			}
+			if _, err := ins.Exec(vals...); err != nil {
+				return err
+			}
+			// only once the row has been applied, as a rejected row's
+			// delete is rolled back with it
+			seen[k] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fp.row()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input: %v", err)
+	}
+
+	d.report(atomic.LoadInt64(&fp.rows))
+	return nil
+}
+
+// readDeltaDeletes removes, in tx, all rows in the table for each key listed
+// in f. The entities changed are added to touched.
+func readDeltaDeletes(tx *sql.Tx, f edmFile, ft factset.File, opts loadOptions, fp *fileProgress, touched touchedEntities) error {
+	scanner, rc, err := openScanner(f, &fp.read, opts.encoding)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	cols := columnMap{table: ft.Table}
+	if err := cols.mapKey(ft, scanner.Header()); err != nil {
+		return err
+	}
+
+	fsidCol := headerIndex(scanner.Header(), "FACTSET_ENTITY_ID")
+
+	del, err := tx.Prepare(cols.deleteStmt())
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+
+	d := &deltaRows{tx: tx, file: f.name, table: ft.Table, opts: opts}
+	for scanner.Scan() {
+		err := d.apply(scanner, func(row []interface{}) error {
+			if fsidCol >= 0 {
+				touched.add(row[fsidCol].(string))
+			}
+			_, err := del.Exec(cols.keyValues(row)...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fp.row()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input: %v", err)
+	}
+	d.report(0)
+	return nil
+}
+
+// deltaRows applies the rows of a delta file one at a time. A row that
+// cannot be applied fails the delta or, if opts.skipBadRows, is recorded
+// in import_rejects and left out. Each row is then applied under its own
+// savepoint, so that one that fails does not abort the delta's
+// transaction.
+type deltaRows struct {
+	tx       *sql.Tx
+	file     string
+	table    string
+	opts     loadOptions
+	rejected int64
+}
+
+// apply parses the scanner's current row and applies it with f.
+func (d *deltaRows) apply(scanner *scanner, f func(row []interface{}) error) error {
+	rowErr := func(err error) *rowError {
+		return &rowError{file: d.file, line: scanner.Line(), raw: scanner.Text(), err: err}
+	}
+
+	row, err := scanner.Row()
+	if err != nil {
+		return d.reject(rowErr(err))
+	}
+	if !d.opts.skipBadRows {
+		if err := f(row); err != nil {
+			return rowErr(err)
+		}
+		return nil
+	}
+
+	if _, err := d.tx.Exec("SAVEPOINT row;"); err != nil {
+		return err
+	}
+	if err := f(row); err != nil {
+		if _, err := d.tx.Exec("ROLLBACK TO SAVEPOINT row;"); err != nil {
+			return err
+		}
+		return d.reject(rowErr(err))
+	}
+	_, err = d.tx.Exec("RELEASE SAVEPOINT row;")
+	return err
+}
+
+func (d *deltaRows) reject(e *rowError) error {
+	if !d.opts.skipBadRows {
+		return e
+	}
+	d.rejected++
+	return saveReject(d.tx, e)
+}
+
+// report logs how many rows were rejected, and records the rows applied,
+// less those rejected, and rejected in the metrics.
+func (d *deltaRows) report(rows int64) {
+	if rows > 0 {
+		rowsLoaded.WithLabelValues(d.table).Add(float64(rows - d.rejected))
+	}
+	if d.rejected == 0 {
+		return
+	}
+	rowsRejected.WithLabelValues(d.table).Add(float64(d.rejected))
+	log.Printf("%s: rejected %d rows. they are recorded in import_rejects\n", d.file, d.rejected)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dryRunErrors is the number of bad rows of each file that a dry run lists.
+const dryRunErrors = 10
+
+// dryRunResult is what a dry run found in one file.
+type dryRunResult struct {
+	file    string
+	table   string
+	rows    int64 // rows that would be loaded
+	invalid int64 // rows that would be rejected
+	bytes   int64
+	errors  []string // the first few bad rows
+	err     error    // what stopped the file being read, if anything
+}
+
+// runDryRun parses every mapped file of the delivery at edmPath, converting
+// each row to its column types as the mapping declares them, and reports
+// what it finds without touching a database. It exits with an error if any
+// row would be rejected.
+func runDryRun(edmPath string, opts loadOptions, fsids []string, sample int) {
+	edm, err := openEDM(edmPath, opts.stdinName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer edm.Close()
+
+	if len(fsids) > 0 || sample > 0 {
+		opts.entities, err = selectEntities(edm, opts, fsids, sample)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	files := make(chan edmFile)
+	results := make(chan dryRunResult)
+	wg := sync.WaitGroup{}
+	for w := 0; w < max(opts.workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				results <- checkFile(f, opts)
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range edm.files {
+			if _, ok := opts.file(file.name); !ok {
+				fmt.Fprintf(os.Stderr, "we have no use for %s\n", file.name)
+				continue
+			}
+			files <- file
+		}
+		close(files)
+		wg.Wait()
+		close(results)
+	}()
+
+	var summary []dryRunResult
+	for res := range results {
+		summary = append(summary, res)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].file < summary[j].file })
+
+	var rows, invalid int64
+	failed := false
+	for _, res := range summary {
+		rows += res.rows
+		invalid += res.invalid
+		fields := map[string]interface{}{"file": res.file, "table": res.table, "rows": res.rows, "invalid": res.invalid, "bytes": res.bytes}
+		if len(res.errors) > 0 {
+			fields["errors"] = res.errors
+		}
+		if res.err != nil {
+			failed = true
+			fields["error"] = res.err.Error()
+			logEvent("file_failed", fields, fmt.Sprintf("%s: failed after %d rows: %v", res.file, res.rows+res.invalid, res.err))
+			continue
+		}
+		logEvent("file_checked", fields, fmt.Sprintf("%s: %d rows for %s, %d invalid, %d bytes", res.file, res.rows, res.table, res.invalid, res.bytes))
+		if !jsonLogs {
+			for _, e := range res.errors {
+				log.Printf("  %s\n", e)
+			}
+			if res.invalid > int64(len(res.errors)) {
+				log.Printf("  and %d more\n", res.invalid-int64(len(res.errors)))
+			}
+		}
+	}
+
+	logEvent("summary", map[string]interface{}{"rows": rows, "invalid": invalid, "seconds": time.Since(start).Seconds()},
+		fmt.Sprintf("%d rows would be loaded and %d rejected, checked in %v", rows, invalid, time.Since(start).Round(time.Second)))
+	if failed || invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkFile parses f as a load would, without writing anything.
+func checkFile(f edmFile, opts loadOptions) dryRunResult {
+	ft, _ := opts.file(f.name)
+	res := dryRunResult{file: f.name, table: ft.Table}
+
+	scanner, rc, err := openScanner(f, &res.bytes, opts.encoding)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer rc.Close()
+
+	existing, err := declaredColumns(ft)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	var add func(name string) error
+	if opts.addColumns {
+		add = func(name string) error { return nil }
+	}
+	cols, err := matchColumns(f.name, ft, existing, scanner.Header(), add)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	filter := opts.entities.column(scanner.Header())
+
+	for scanner.Scan() {
+		fields, err := scanner.Row()
+		if err == nil && filter >= 0 && !opts.entities[fields[filter].(string)] {
+			continue
+		}
+		if err == nil {
+			_, err = cols.values(fields)
+		}
+		if err != nil {
+			res.invalid++
+			if len(res.errors) < dryRunErrors {
+				res.errors = append(res.errors, fmt.Sprintf("line %d: %v", scanner.Line(), err))
+			}
+			continue
+		}
+		res.rows++
+	}
+	res.err = scanner.Err()
+	return res
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodings are the values accepted by --encoding.
+var encodings = []string{"windows-1252", "utf-8", "latin-1", "auto"}
+
+func checkEncoding(encoding string) error {
+	for _, e := range encodings {
+		if e == encoding {
+			return nil
+		}
+	}
+	return fmt.Errorf("--encoding must be one of %v, not %q", encodings, encoding)
+}
+
+// sniffSize is how much of a file auto detection looks at.
+const sniffSize = 64 << 10
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// decodeSource returns a reader of r's text as UTF-8, and the encoding it
+// was read as. With "auto", a byte order mark decides the encoding, and
+// otherwise the file is taken to be UTF-8 if its start is valid UTF-8 and
+// windows-1252 if not.
+//
+// Bytes that are not valid in the encoding come through as U+FFFD, or as
+// they are for UTF-8, for the scanner to reject.
+func decodeSource(r io.Reader, encoding string) (io.Reader, string, error) {
+	switch encoding {
+	case "windows-1252":
+		return charmap.Windows1252.NewDecoder().Reader(r), encoding, nil
+	case "latin-1":
+		return charmap.ISO8859_1.NewDecoder().Reader(r), encoding, nil
+	}
+
+	br := bufio.NewReaderSize(r, sniffSize)
+	start, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(start, utf8BOM):
+		br.Discard(len(utf8BOM))
+		return br, "utf-8", nil
+	case encoding == "utf-8":
+		return br, encoding, nil
+	case bytes.HasPrefix(start, utf16LEBOM):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Reader(br), "utf-16le", nil
+	case bytes.HasPrefix(start, utf16BEBOM):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Reader(br), "utf-16be", nil
+	case utf8.Valid(trimPartialRune(start)):
+		return br, "utf-8", nil
+	default:
+		return charmap.Windows1252.NewDecoder().Reader(br), "windows-1252", nil
+	}
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b, as
+// left by cutting a file short.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// openScanner opens f and starts scanning it as text in encoding. read is
+// passed on to f.open.
+func openScanner(f edmFile, read *int64, encoding string) (*scanner, io.Closer, error) {
+	rc, err := f.open(read)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, detected, err := decodeSource(rc, encoding)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	if encoding == "auto" {
+		log.Printf("%s: reading as %s\n", f.name, detected)
+	}
+	s, err := NewScanner(r)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	return s, rc, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func utf16Text(t *testing.T, endianness unicode.Endianness, s string) []byte {
+	t.Helper()
+	b, err := unicode.UTF16(endianness, unicode.UseBOM).NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeSource(t *testing.T) {
+	// a file whose first 64KB ends part way through a two byte rune
+	split := strings.Repeat("a", sniffSize-1) + "é\n"
+
+	tests := []struct {
+		name     string
+		encoding string
+		in       []byte
+		detected string
+		want     string
+	}{
+		{"utf-8 bom", "auto", []byte("\xef\xbb\xbfA|é\n"), "utf-8", "A|é\n"},
+		{"utf-16le bom", "auto", utf16Text(t, unicode.LittleEndian, "A|é\n"), "utf-16le", "A|é\n"},
+		{"utf-16be bom", "auto", utf16Text(t, unicode.BigEndian, "A|é\n"), "utf-16be", "A|é\n"},
+		{"valid utf-8", "auto", []byte("A|é\n"), "utf-8", "A|é\n"},
+		{"not utf-8", "auto", []byte("A|\xe9\x80\n"), "windows-1252", "A|é€\n"},
+		{"rune split at sniffSize", "auto", []byte(split), "utf-8", split},
+		{"windows-1252 before sniffSize", "auto", []byte(strings.Repeat("a", sniffSize-2) + "\xe9a\n"), "windows-1252", strings.Repeat("a", sniffSize-2) + "éa\n"},
+		{"empty", "auto", nil, "utf-8", ""},
+		{"windows-1252", "windows-1252", []byte("A|\xe9\x80\n"), "windows-1252", "A|é€\n"},
+		{"latin-1", "latin-1", []byte("A|\xe9\n"), "latin-1", "A|é\n"},
+		{"utf-8 drops a bom", "utf-8", []byte("\xef\xbb\xbfA\n"), "utf-8", "A\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, detected, err := decodeSource(bytes.NewReader(tt.in), tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected != tt.detected {
+				t.Errorf("read as %s, want %s", detected, tt.detected)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %.40q..., want %.40q...", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimPartialRune(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{"abé", "abé"},
+		{"ab\xc3", "ab"},
+		{"ab\xe2\x82", "ab"},
+		{"ab€", "ab€"},
+		{"ab\xf0\x9f\x98", "ab"},
+		// a windows-1252 é looks like the start of a cut rune, so it goes
+		// too; it is only ever the last byte looked at
+		{"ab\xe9", "ab"},
+		{"ab\xe9c", "ab\xe9c"},
+	}
+	for _, tt := range tests {
+		if got := string(trimPartialRune([]byte(tt.in))); got != tt.want {
+			t.Errorf("trimPartialRune(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestInvalidBytesRejected checks that bytes not valid in the encoding make
+// their row fail, naming its line, column and the offset of the byte.
+func TestInvalidBytesRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		bad      string
+	}{
+		{"windows-1252 0x81", "windows-1252", "\x81"},
+		{"windows-1252 0x8d", "windows-1252", "\x8d"},
+		{"windows-1252 0x8f", "windows-1252", "\x8f"},
+		{"windows-1252 0x90", "windows-1252", "\x90"},
+		{"windows-1252 0x9d", "windows-1252", "\x9d"},
+		{"utf-8", "utf-8", "\xe9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := "A|B\nok|fine\nok|ab" + tt.bad + "c\nok|fine\n"
+			r, _, err := decodeSource(strings.NewReader(in), tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := NewScanner(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var bad []int64
+			for s.Scan() {
+				if _, err := s.Row(); err != nil {
+					if want := "B: invalid byte at offset 2"; err.Error() != want {
+						t.Errorf("line %d: got %q, want %q", s.Line(), err, want)
+					}
+					bad = append(bad, s.Line())
+				}
+			}
+			if err := s.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if len(bad) != 1 || bad[0] != 3 {
+				t.Errorf("rejected lines %v, want [3]", bad)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+// createIndexes builds every index, opts.indexWorkers at a time, logging
+// how long each one took. All indexes are attempted; the first failure is
+// returned.
+func createIndexes(db *sql.DB, opts loadOptions) error {
+	workers := opts.indexWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := factset.Indexes(opts.files)
+	todo := make(chan factset.Index)
+	errs := make(chan error, len(indexes))
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				start := time.Now()
+				if _, err := db.Exec(i.CreateStmt(opts.indexConcurrently)); err != nil {
+					errs <- fmt.Errorf("failed to create index %s: %v", i.Name, err)
+					continue
+				}
+				log.Printf("created index %s in %v\n", i.Name, time.Now().Sub(start))
+			}
+		}()
+	}
+
+	start := time.Now()
+	for _, i := range indexes {
+		todo <- i
+	}
+	close(todo)
+	wg.Wait()
+	close(errs)
+
+	var first error
+	for err := range errs {
+		log.Println(err)
+		if first == nil {
+			first = err
+		}
+	}
+	if first == nil {
+		log.Printf("created %d indexes in %v\n", len(indexes), time.Now().Sub(start))
+	}
+	return first
+}
+
+// syncIndexes creates, in tx, any of the indexes an import builds on the
+// tables of files that the schema lacks, as when an index is added after
+// the schema was loaded. Indexes on tables the schema does not have are
+// left for the import that creates them.
+func syncIndexes(tx *sql.Tx, files []factset.File) error {
+	for _, i := range factset.Indexes(files) {
+		var missing bool
+		err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL AND to_regclass($2) IS NULL;`, i.Table, i.Name).Scan(&missing)
+		if err != nil {
+			return err
+		}
+		if !missing {
+			continue
+		}
+
+		start := time.Now()
+		if _, err := tx.Exec(i.CreateStmt(false)); err != nil {
+			return fmt.Errorf("failed to create index %s: %v", i.Name, err)
+		}
+		log.Printf("created index %s in %v\n", i.Name, time.Now().Sub(start))
+	}
+	return nil
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+// loadOptions controls which files are loaded and how rows are written to
+// the database.
+type loadOptions struct {
+	// files maps the files to load onto their tables.
+	files []factset.File
+	// copy streams each file with COPY FROM STDIN rather than running one
+	// INSERT per row.
+	copy bool
+	// skipBadRows records rows that cannot be loaded in import_rejects and
+	// carries on, rather than stopping the load.
+	skipBadRows bool
+	// encoding is the text encoding of the files, or auto to detect it.
+	encoding string
+	// stdinName names the file read from stdin when it is not a zip
+	// archive.
+	stdinName string
+	// addColumns adds columns that appear in a file but not in its table,
+	// rather than ignoring them.
+	addColumns bool
+	// workers is the number of files loaded at once.
+	workers int
+	// chunks is the number of batches of each file written at once, each
+	// on its own connection.
+	chunks int
+	// indexConcurrently builds indexes with CREATE INDEX CONCURRENTLY.
+	indexConcurrently bool
+	// indexWorkers is the number of indexes built at once.
+	indexWorkers int
+	// entities, if set, limits the load to the rows of a sample of
+	// entities.
+	entities entityFilter
+}
+
+// file returns the mapping for the named file, if it is loaded.
+func (opts loadOptions) file(name string) (factset.File, bool) {
+	for _, f := range opts.files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return factset.File{}, false
+}
+
+// createTables creates any mapped tables that do not exist yet, such as
+// those added by a mapping file.
+func (opts loadOptions) createTables(db *sql.DB) error {
+	for _, f := range opts.files {
+		if _, err := db.Exec(f.CreateStmt()); err != nil {
+			return fmt.Errorf("failed to create %s: %v", f.Table, err)
+		}
+	}
+	return nil
+}
+
+// conns is the number of connections a load needs: one per batch being
+// written at once. The uuid mapping and materialisation that follow each
+// run in a single transaction, so need only one.
+func (opts loadOptions) conns() int {
+	return max(opts.workers, 1) * max(opts.chunks, 1)
+}
+
+func (opts loadOptions) method() string {
+	if opts.copy {
+		return "COPY"
+	}
+	return "INSERT"
+}
+
+const (
+	insertBatchSize = 1024
+	copyBatchSize   = 1 << 16
+)
+
+func (opts loadOptions) batchSize() int {
+	if opts.copy {
+		return copyBatchSize
+	}
+	return insertBatchSize
+}
+
+// loadAll loads every mapped file of a delivery, picking up from any
+// checkpoints left by an earlier attempt to load the same file.
+func loadAll(edm *edmFiles, db *sql.DB, opts loadOptions) error {
+
+	if err := migrateSchema(db, factset.LatestVersion()); err != nil {
+		return err
+	}
+	if err := opts.createTables(db); err != nil {
+		return err
+	}
+	for _, stmt := range []string{checkpointsTable, rejectsTable, orgsTable} {
+		_, err := db.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	checkpoints, err := loadCheckpoints(db)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range edm.files {
+		if cp, ok := checkpoints[file.name]; ok && cp.source != file.source {
+			return fmt.Errorf("%s was partially loaded from %s, not %s", file.name, cp.source, file.source)
+		}
+	}
+
+	p := startProgress(opts.method())
+
+	// the first file to fail stops the others
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// files are loaded opts.workers at a time
+	jobs := make(chan fileJob)
+	results := make(chan fileResult)
+	wg := sync.WaitGroup{}
+	for w := 0; w < max(opts.workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				fp := p.file(job.file, job.ft.Table)
+				res := readFactset(ctx, db, job, opts, fp)
+				p.finish(fp, res.err)
+				if res.err != nil {
+					cancel()
+				}
+				results <- res
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range edm.files {
+			ft, ok := opts.file(file.name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "we have no use for %s\n", file.name)
+				continue
+			}
+			cp := checkpoints[file.name]
+			if cp.complete {
+				log.Printf("%s already loaded. skipping\n", file.name)
+				continue
+			}
+			cp.file, cp.source = file.name, file.source
+			jobs <- fileJob{file, ft, cp}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var summary []fileResult
+	for res := range results {
+		summary = append(summary, res)
+	}
+
+	p.Stop()
+
+	if err := reportResults(summary); err != nil {
+		return err
+	}
+
+	log.Println("creating uuid mapping")
+	if err := inTx(db, mapUUIDs); err != nil {
+		return err
+	}
+	log.Println("done uuid mapping")
+
+	if err := createIndexes(db, opts); err != nil {
+		return err
+	}
+
+	// after indexing, so that each entity's rows are found quickly
+	return inTx(db, func(tx *sql.Tx) error {
+		return materialiseOrgs(tx, nil, false)
+	})
+}
+
+// inTx calls f in a transaction, which is committed if f succeeds.
+func inTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mapUUIDs adds, in tx, a uuid_to_fsid row for every entity that does not
+// already have one.
+func mapUUIDs(tx *sql.Tx) error {
+	var fsids []string
+	scan := func(rows *sql.Rows) error {
+		var fsid string
+		if err := rows.Scan(&fsid); err != nil {
+			return err
+		}
+		fsids = append(fsids, fsid)
+		return nil
+	}
+	flush := func() error {
+		s, err := tx.Prepare("INSERT into uuid_to_fsid VALUES($1, $2)")
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		for _, fsid := range fsids {
+			if _, err := s.Exec(factset.UUIDFromFsid(fsid), fsid); err != nil {
+				return err
+			}
+		}
+		fsids = fsids[:0]
+		return nil
+	}
+	return cursorBatches(tx, "unmapped", `SELECT e.FACTSET_ENTITY_ID FROM fsEntity e WHERE NOT EXISTS (SELECT 1 FROM uuid_to_fsid u WHERE u.FACTSET_ENTITY_ID = e.FACTSET_ENTITY_ID)`, scan, flush)
+}
+
+// fileResult summarises the load of one file.
+type fileResult struct {
+	file     string
+	loaded   int64
+	rejected int64
+	err      error
+}
+
+// reportResults logs a summary of the load of each file and returns the
+// error that stopped the load, if any.
+func reportResults(results []fileResult) error {
+	sort.Slice(results, func(i, j int) bool { return results[i].file < results[j].file })
+
+	var loaded, rejected int64
+	var failed error
+	for _, res := range results {
+		loaded += res.loaded
+		rejected += res.rejected
+		fields := map[string]interface{}{"file": res.file, "loaded": res.loaded, "rejected": res.rejected}
+		switch res.err {
+		case nil:
+			logEvent("file_loaded", fields, fmt.Sprintf("%s: loaded %d rows, rejected %d", res.file, res.loaded, res.rejected))
+		case context.Canceled:
+			logEvent("file_stopped", fields, fmt.Sprintf("%s: stopped after loading %d rows", res.file, res.loaded))
+		default:
+			fields["error"] = res.err.Error()
+			logEvent("file_failed", fields, fmt.Sprintf("%s: failed after loading %d rows: %v", res.file, res.loaded, res.err))
+			if failed == nil {
+				failed = fileError(res.file, res.err)
+			}
+		}
+	}
+
+	logEvent("summary", map[string]interface{}{"loaded": loaded, "rejected": rejected},
+		fmt.Sprintf("loaded %d rows in total, rejected %d", loaded, rejected))
+	if rejected > 0 {
+		log.Println("rejected rows are recorded in import_rejects")
+	}
+	return failed
+}
+
+// pendingRow is a row that has been read but not yet committed. A row that
+// could not be parsed has err set and is rejected when its batch is
+// committed.
+type pendingRow struct {
+	line int64
+	raw  string
+	vals []interface{}
+	err  error
+}
+
+// fileJob is a file waiting to be loaded.
+type fileJob struct {
+	file edmFile
+	ft   factset.File
+	cp   checkpoint
+}
+
+// fileLoader loads the rows of one file into its table.
+type fileLoader struct {
+	db   *sql.DB
+	ft   factset.File
+	cols columnMap
+	cp   checkpoint // the checkpoint the load started from
+	opts loadOptions
+	prog *fileProgress
+	// filter is the index of the column that opts.entities is matched
+	// against, or -1 to load every row.
+	filter int
+
+	mu  sync.Mutex // guards res
+	res fileResult
+}
+
+// batchJob is a batch of rows waiting to be written, with the checkpoint
+// that follows it.
+type batchJob struct {
+	rows []pendingRow
+	cp   checkpoint
+	prev *turn // the previous batch's turn
+	done *turn
+}
+
+// turn lets batches be written in parallel but committed in order, so that
+// a checkpoint never counts rows that have not been committed. A batch
+// waits for the previous one's turn to finish before committing, and fails
+// if it did.
+type turn struct {
+	finished chan struct{}
+	err      error
+}
+
+func newTurn() *turn {
+	return &turn{finished: make(chan struct{})}
+}
+
+func (t *turn) finish(err error) {
+	t.err = err
+	close(t.finished)
+}
+
+func (t *turn) wait() error {
+	<-t.finished
+	return t.err
+}
+
+// readFactset loads the rows of f, committing every batch along with a
+// checkpoint recording how many rows have been loaded so far. Rows already
+// committed according to cp are skipped.
+func readFactset(ctx context.Context, db *sql.DB, job fileJob, opts loadOptions, prog *fileProgress) fileResult {
+	l := &fileLoader{db: db, ft: job.ft, cp: job.cp, opts: opts, prog: prog, res: fileResult{file: job.file.name}}
+	l.res.err = l.load(ctx, job.file)
+	return l.res
+}
+
+func (l *fileLoader) load(ctx context.Context, f edmFile) error {
+	scanner, rc, err := openScanner(f, &l.prog.read, l.opts.encoding)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	l.cols, err = mapColumns(l.db, f.name, l.ft, scanner.Header(), l.opts.addColumns)
+	if err != nil {
+		return err
+	}
+	l.filter = l.opts.entities.column(scanner.Header())
+
+	if l.cp.rows > 0 {
+		log.Printf("resuming %s after row %d\n", f.name, l.cp.rows)
+	}
+	for skipped := int64(0); skipped < l.cp.rows && scanner.Scan(); skipped++ {
+	}
+
+	// a failed batch stops the reading
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan batchJob)
+	wg := sync.WaitGroup{}
+	for w := 0; w < max(l.opts.chunks, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				err := l.commit(job)
+				if err != nil {
+					cancel()
+				}
+				job.done.finish(err)
+			}
+		}()
+	}
+
+	last := newTurn()
+	last.finish(nil)
+	cp := l.cp
+	dispatch := func(batch []pendingRow, scanned int64, complete bool) {
+		cp.rows += scanned
+		cp.complete = complete
+		job := batchJob{rows: batch, cp: cp, prev: last, done: newTurn()}
+		last = job.done
+		jobs <- job
+	}
+
+	err = l.read(ctx, scanner, dispatch)
+	close(jobs)
+	wg.Wait()
+
+	// a failed batch is what cancelled the read, if it was cancelled
+	if werr := last.wait(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// read parses the rows of the file and hands them to dispatch in batches,
+// along with the number of rows scanned for each, the last of which is
+// marked complete. Rows of entities outside a sample are scanned but not
+// loaded.
+func (l *fileLoader) read(ctx context.Context, scanner *scanner, dispatch func([]pendingRow, int64, bool)) error {
+	batch := make([]pendingRow, 0, l.opts.batchSize())
+	var scanned int64
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		scanned++
+		l.prog.row()
+		row := pendingRow{line: scanner.Line(), raw: scanner.Text()}
+		fields, err := scanner.Row()
+		if err == nil && l.filter >= 0 && !l.opts.entities[fields[l.filter].(string)] {
+			continue
+		}
+		if err == nil {
+			row.vals, err = l.cols.values(fields)
+		}
+		if err != nil {
+			if !l.opts.skipBadRows {
+				return l.rowError(row, err)
+			}
+			row.err = err
+		}
+		batch = append(batch, row)
+		if len(batch) == cap(batch) {
+			dispatch(batch, scanned, false)
+			batch = make([]pendingRow, 0, l.opts.batchSize())
+			scanned = 0
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		// keep what was read successfully, so a resume starts from there
+		dispatch(batch, scanned, false)
+		return fmt.Errorf("error reading input: %v", err)
+	}
+
+	dispatch(batch, scanned, true)
+	return nil
+}
+
+// commit writes a batch and the checkpoint that follows it in a single
+// transaction, committed once the previous batch has been. If writing fails,
+// the batch is written again a row at a time to find the rows responsible.
+func (l *fileLoader) commit(job batchJob) error {
+	start := time.Now()
+	rejected, err := l.writeBatch(job)
+	if err != nil {
+		if perr := job.prev.wait(); perr != nil {
+			return perr
+		}
+		rejected, err = l.replayBatch(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	commitSeconds.WithLabelValues(l.ft.Table).Observe(time.Now().Sub(start).Seconds())
+	rowsLoaded.WithLabelValues(l.ft.Table).Add(float64(len(job.rows) - rejected))
+	rowsRejected.WithLabelValues(l.ft.Table).Add(float64(rejected))
+
+	l.mu.Lock()
+	l.res.loaded += int64(len(job.rows) - rejected)
+	l.res.rejected += int64(rejected)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *fileLoader) rowError(row pendingRow, err error) *rowError {
+	return &rowError{file: l.res.file, line: row.line, raw: row.raw, err: err}
+}
+
+// writeBatch writes the rows of batch that could be parsed, and records
+// those that could not in import_rejects, returning how many there were.
+func (l *fileLoader) writeBatch(job batchJob) (rejected int, err error) {
+	stmt := l.cols.insertStmt()
+	if l.opts.copy {
+		stmt = l.cols.copyStmt()
+	}
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	s, err := tx.Prepare(stmt)
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range job.rows {
+		if row.err != nil {
+			continue
+		}
+		if _, err := s.Exec(row.vals...); err != nil {
+			return 0, err
+		}
+	}
+	if l.opts.copy {
+		// an Exec with no arguments flushes the buffered COPY data
+		if _, err := s.Exec(); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, row := range job.rows {
+		if row.err != nil {
+			if err := saveReject(tx, l.rowError(row, row.err)); err != nil {
+				return 0, err
+			}
+			rejected++
+		}
+	}
+
+	if err := job.prev.wait(); err != nil {
+		return 0, err
+	}
+	if err := saveCheckpoint(tx, job.cp); err != nil {
+		return 0, err
+	}
+	return rejected, tx.Commit()
+}
+
+// replayBatch inserts each row of batch under its own savepoint. A row that
+// fails is returned as a *rowError or, if opts.skipBadRows, recorded in
+// import_rejects and left out.
+func (l *fileLoader) replayBatch(job batchJob) (rejected int, err error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	s, err := tx.Prepare(l.cols.insertStmt())
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range job.rows {
+		if row.err != nil {
+			if err := saveReject(tx, l.rowError(row, row.err)); err != nil {
+				return 0, err
+			}
+			rejected++
+			continue
+		}
+		if _, err := tx.Exec("SAVEPOINT row;"); err != nil {
+			return 0, err
+		}
+		if _, err := s.Exec(row.vals...); err != nil {
+			rerr := l.rowError(row, err)
+			if !l.opts.skipBadRows {
+				return 0, rerr
+			}
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT row;"); err != nil {
+				return 0, err
+			}
+			if err := saveReject(tx, rerr); err != nil {
+				return 0, err
+			}
+			rejected++
+			continue
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT row;"); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := job.prev.wait(); err != nil {
+		return 0, err
+	}
+	if err := saveCheckpoint(tx, job.cp); err != nil {
+		return 0, err
+	}
+	return rejected, tx.Commit()
+}
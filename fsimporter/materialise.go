@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/jawher/mow.cli"
+	"github.com/lib/pq"
+
+	"github.com/Financial-Times/fs-sql-spike/dbconn"
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+// orgsTable holds each entity's org as org-transformer serves it, so that it
+// need not be assembled from the FactSet tables on every request. Orgs
+// missing from it are assembled as before.
+const orgsTable = `
+CREATE TABLE IF NOT EXISTS fsOrgs (
+	UUID              char(36) PRIMARY KEY,
+	FACTSET_ENTITY_ID char(8) NOT NULL,
+	ORG               jsonb NOT NULL
+);
+CREATE INDEX IF NOT EXISTS fsOrgs_fsid ON fsOrgs (FACTSET_ENTITY_ID);`
+
+func materialiseCmd(cmd *cli.Cmd) {
+	conf := dbconn.Options(cmd)
+
+	schema := schemaOpt(cmd, "version to rebuild the orgs of")
+
+	dbName := cmd.String(cli.StringArg{
+		Name:   "DBNAME",
+		Desc:   "database schema name",
+		EnvVar: "FSIMPORT_DB_NAME",
+	})
+
+	cmd.Action = func() { runMaterialise(conf, *dbName, *schema) }
+}
+
+// runMaterialise rebuilds every org of a schema in a single transaction, as
+// is needed when the way orgs are built changes. Readers see the old orgs
+// until it commits.
+func runMaterialise(conf *dbconn.Config, dbName string, schema string) {
+	adminDB, current, schema := openSchema(conf, dbName, schema)
+	defer adminDB.Close()
+
+	db, err := conf.Open(dbName, schema)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(orgsTable); err != nil {
+		log.Fatal(err)
+	}
+	err = inTx(db, func(tx *sql.Tx) error {
+		return materialiseOrgs(tx, nil, true)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if schema == current {
+		// the alias schema has no view of fsOrgs if it has just been
+		// created
+		if err := refreshAliasViews(adminDB, current); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// materialiseOrgs builds, in tx, the orgs in fsOrgs of the entities that
+// have none and of those in stale, replacing the ones they have, or, if
+// rebuild is set, of every entity.
+func materialiseOrgs(tx *sql.Tx, stale []string, rebuild bool) error {
+	log.Println("materialising orgs")
+
+	// orgs that no longer have an entity are removed whatever happens
+	if _, err := tx.Exec(`DELETE FROM fsOrgs o WHERE NOT EXISTS (SELECT 1 FROM uuid_to_fsid u WHERE u.UUID = o.UUID);`); err != nil {
+		return err
+	}
+
+	q := factset.OrgSelect
+	if rebuild {
+		if _, err := tx.Exec("DELETE FROM fsOrgs;"); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec("DELETE FROM fsOrgs WHERE FACTSET_ENTITY_ID = ANY($1);", pq.Array(stale)); err != nil {
+			return err
+		}
+		// the stale orgs have gone, so they are among those missing
+		q += " WHERE NOT EXISTS (SELECT 1 FROM fsOrgs o WHERE o.UUID = u.UUID)"
+	}
+
+	var orgs []factset.Org
+	scan := func(rows *sql.Rows) error {
+		o, err := factset.ScanOrg(rows)
+		if err != nil {
+			return err
+		}
+		orgs = append(orgs, o)
+		return nil
+	}
+	n := 0
+	flush := func() error {
+		s, err := tx.Prepare("COPY fsOrgs (UUID, FACTSET_ENTITY_ID, ORG) FROM STDIN;")
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		for _, o := range orgs {
+			doc, err := json.Marshal(o)
+			if err != nil {
+				return err
+			}
+			if _, err := s.Exec(o.UUID, o.AlternativeIdentifiers.FactsetIdentifier, string(doc)); err != nil {
+				return err
+			}
+		}
+		// an Exec with no arguments flushes the buffered COPY data
+		if _, err := s.Exec(); err != nil {
+			return err
+		}
+		n += len(orgs)
+		orgs = orgs[:0]
+		return nil
+	}
+	if err := cursorBatches(tx, "unmaterialised", q, scan, flush); err != nil {
+		return err
+	}
+
+	log.Printf("materialised %d orgs\n", n)
+	return nil
+}
+
+// touchedEntities collects the FACTSET_ENTITY_IDs of the entities whose
+// rows a delta changes, so that their orgs can be rebuilt once it has been
+// applied.
+type touchedEntities map[string]bool
+
+func (t touchedEntities) add(fsid string) {
+	t[fsid] = true
+}
+
+func (t touchedEntities) list() []string {
+	fsids := make([]string, 0, len(t))
+	for fsid := range t {
+		fsids = append(fsids, fsid)
+	}
+	return fsids
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	rowsRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fsimporter",
+		Name:      "rows_read_total",
+		Help:      "Rows read from each file.",
+	}, []string{"file"})
+
+	bytesRead = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fsimporter",
+		Name:      "file_read_bytes",
+		Help:      "Bytes read so far from each file, as stored.",
+	}, []string{"file"})
+
+	fileSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fsimporter",
+		Name:      "file_size_bytes",
+		Help:      "Size of each file, as stored.",
+	}, []string{"file"})
+
+	fileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fsimporter",
+		Name:      "file_errors_total",
+		Help:      "Files whose load failed.",
+	}, []string{"file"})
+
+	rowsLoaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fsimporter",
+		Name:      "rows_loaded_total",
+		Help:      "Rows committed to each table.",
+	}, []string{"table"})
+
+	rowsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fsimporter",
+		Name:      "rows_rejected_total",
+		Help:      "Rows recorded in import_rejects instead of being loaded into each table.",
+	}, []string{"table"})
+
+	commitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "fsimporter",
+		Name:      "commit_duration_seconds",
+		Help:      "Time taken to write and commit each batch, by table.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"table"})
+)
+
+func init() {
+	prometheus.MustRegister(rowsRead, bytesRead, fileSize, fileErrors, rowsLoaded, rowsRejected, commitSeconds)
+}
+
+// serveMetrics serves Prometheus metrics on /metrics, alongside the
+// net/http/pprof handlers, if port is not 0.
+func serveMetrics(port int) {
+	if port == 0 {
+		return
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	}()
+	log.Printf("serving metrics on port %d\n", port)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jawher/mow.cli"
+
+	"github.com/Financial-Times/fs-sql-spike/dbconn"
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+func migrateCmd(cmd *cli.Cmd) {
+	cmd.Command("up", "apply schema migrations", func(cmd *cli.Cmd) {
+		conf, dbName, schema := migrateArgs(cmd)
+		to := cmd.Int(cli.IntOpt{
+			Name:  "to",
+			Value: -1,
+			Desc:  "version to migrate to. Defaults to the latest",
+		})
+		cmd.Action = func() {
+			runMigrate(conf, *dbName, *schema, func(current int) int {
+				if *to < 0 {
+					return factset.LatestVersion()
+				}
+				return *to
+			})
+		}
+	})
+
+	cmd.Command("down", "revert schema migrations", func(cmd *cli.Cmd) {
+		conf, dbName, schema := migrateArgs(cmd)
+		to := cmd.Int(cli.IntOpt{
+			Name:  "to",
+			Value: -1,
+			Desc:  "version to revert to. Defaults to the one before the schema's current version",
+		})
+		cmd.Action = func() {
+			runMigrate(conf, *dbName, *schema, func(current int) int {
+				if *to < 0 {
+					return current - 1
+				}
+				return *to
+			})
+		}
+	})
+
+	cmd.Command("status", "show which schema migrations have been applied", func(cmd *cli.Cmd) {
+		conf, dbName, schema := migrateArgs(cmd)
+		cmd.Action = func() { runMigrateStatus(conf, *dbName, *schema) }
+	})
+}
+
+// migrateArgs registers the options and arguments shared by the migrate
+// subcommands.
+func migrateArgs(cmd *cli.Cmd) (*dbconn.Config, *string, *string) {
+	conf := dbconn.Options(cmd)
+
+	schema := schemaOpt(cmd, "schema to migrate")
+
+	dbName := cmd.String(cli.StringArg{
+		Name:   "DBNAME",
+		Desc:   "database schema name",
+		EnvVar: "FSIMPORT_DB_NAME",
+	})
+
+	return conf, dbName, schema
+}
+
+// migrateSchema brings the schema db's search_path points at to the target
+// version.
+func migrateSchema(db *sql.DB, target int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := factset.Migrate(tx, target); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// openSchema opens dbName and works out which schema to migrate.
+func openSchema(conf *dbconn.Config, dbName string, schema string) (db *sql.DB, current string, target string) {
+	db, err := conf.Open(dbName, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := createVersionsTable(db); err != nil {
+		log.Fatal(err)
+	}
+	current, err = currentVersion(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	target = schema
+	if target == "" {
+		target = current
+	}
+	if target == "" {
+		target = "public"
+	}
+	if !identifier.MatchString(target) {
+		log.Fatalf("invalid schema name %q", target)
+	}
+	return db, current, target
+}
+
+// runMigrate migrates a schema to the version chosen by target, given the
+// schema's current version. The views of the alias schema would stop the
+// current version's columns from being changed, so when migrating it they
+// are dropped and recreated in the same transaction; readers wait for the
+// migration rather than seeing it half done. Migrating up also creates any
+// indexes an import would have built that the schema lacks.
+func runMigrate(conf *dbconn.Config, dbName string, schema string, target func(current int) int) {
+	db, current, schema := openSchema(conf, dbName, schema)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("SET LOCAL search_path TO %s;", schema)); err != nil {
+		log.Fatal(err)
+	}
+
+	from, err := factset.SchemaVersion(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	to := target(from)
+
+	if schema == current {
+		if _, err := tx.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE;", aliasSchema)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := factset.Migrate(tx, to); err != nil {
+		log.Fatal(err)
+	}
+	if to >= from {
+		if err := syncIndexes(tx, factset.Files); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if schema == current {
+		if err := createAliasViews(tx, current); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("schema %s migrated from version %d to %d\n", schema, from, to)
+}
+
+func runMigrateStatus(conf *dbconn.Config, dbName string, schema string) {
+	db, _, schema := openSchema(conf, dbName, schema)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("SET LOCAL search_path TO %s;", schema)); err != nil {
+		log.Fatal(err)
+	}
+
+	applied, err := factset.Applied(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	fmt.Printf("schema %s is at version %d of %d\n", schema, version, factset.LatestVersion())
+	for _, m := range factset.Migrations {
+		state := "pending"
+		if at, ok := applied[m.Version]; ok {
+			state = "applied " + at.Format(time.RFC3339)
+		}
+		fmt.Printf("%3d  %-45s %s\n", m.Version, m.Description, state)
+	}
+}
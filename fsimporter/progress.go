@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jsonLogs is set by --log-format json. Every log line is then written as a
+// JSON object, and progress reports carry their figures as fields.
+var jsonLogs bool
+
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+	case "json":
+		jsonLogs = true
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{os.Stderr})
+	default:
+		return fmt.Errorf("--log-format must be text or json, not %q", format)
+	}
+	return nil
+}
+
+// jsonLogWriter turns each line written by the log package into a JSON
+// object with the line as its message.
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+func (j jsonLogWriter) Write(p []byte) (int, error) {
+	err := writeJSON(j.w, map[string]interface{}{"msg": strings.TrimSpace(string(p))})
+	return len(p), err
+}
+
+func writeJSON(w io.Writer, fields map[string]interface{}) error {
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// logEvent logs text, or with --log-format json an object with the event
+// name and fields.
+func logEvent(event string, fields map[string]interface{}, text string) {
+	if !jsonLogs {
+		log.Println(text)
+		return
+	}
+	fields["event"] = event
+	if err := writeJSON(os.Stderr, fields); err != nil {
+		log.Println(err)
+	}
+}
+
+// progress tracks how far a load has got through each of its files,
+// reporting every few seconds until it is stopped.
+type progress struct {
+	method string
+	start  time.Time
+
+	mu    sync.Mutex // guards files
+	files []*fileProgress
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// fileProgress tracks the load of one file. rows and read are updated
+// atomically as the file is loaded.
+type fileProgress struct {
+	name  string
+	table string
+	size  int64
+	read  int64
+	rows  int64
+	start time.Time
+	done  bool // guarded by progress.mu
+
+	rowsRead prometheus.Counter
+}
+
+// startProgress starts reporting progress. method names the load path in
+// use so runs can be compared.
+func startProgress(method string) *progress {
+	p := &progress{
+		method:  method,
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// file starts tracking the load of f into table.
+func (p *progress) file(f edmFile, table string) *fileProgress {
+	fp := &fileProgress{
+		name:     f.name,
+		table:    table,
+		size:     f.size,
+		start:    time.Now(),
+		rowsRead: rowsRead.WithLabelValues(f.name),
+	}
+	fileSize.WithLabelValues(f.name).Set(float64(f.size))
+
+	p.mu.Lock()
+	p.files = append(p.files, fp)
+	p.mu.Unlock()
+	return fp
+}
+
+// row counts a row read from the file.
+func (fp *fileProgress) row() {
+	atomic.AddInt64(&fp.rows, 1)
+	fp.rowsRead.Inc()
+}
+
+// finish stops reporting on a file.
+func (p *progress) finish(fp *fileProgress, err error) {
+	bytesRead.WithLabelValues(fp.name).Set(float64(atomic.LoadInt64(&fp.read)))
+	if err != nil && err != context.Canceled {
+		fileErrors.WithLabelValues(fp.name).Inc()
+	}
+	p.mu.Lock()
+	fp.done = true
+	p.mu.Unlock()
+}
+
+func (p *progress) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var lastCount int64
+	last := p.start
+	for {
+		select {
+		case now := <-ticker.C:
+			count := p.report(now)
+			rate := float64(count) / now.Sub(p.start).Seconds()
+			recent := float64(count-lastCount) / now.Sub(last).Seconds()
+			lastCount, last = count, now
+
+			logEvent("progress", map[string]interface{}{
+				"rows":              count,
+				"rows_per_s":        rate,
+				"recent_rows_per_s": recent,
+				"method":            p.method,
+			}, fmt.Sprintf("count is %d. rate is %.0f rows/s (%.0f rows/s over last interval) using %s", count, rate, recent, p.method))
+		case <-p.stop:
+			count := p.count()
+			dur := time.Now().Sub(p.start)
+			logEvent("loaded", map[string]interface{}{
+				"rows":       count,
+				"seconds":    dur.Seconds(),
+				"rows_per_s": float64(count) / dur.Seconds(),
+				"method":     p.method,
+			}, fmt.Sprintf("loaded %d rows in %v. rate is %.0f rows/s using %s", count, dur, float64(count)/dur.Seconds(), p.method))
+			return
+		}
+	}
+}
+
+// report logs the progress of each file still loading, and returns the
+// rows read from all files.
+func (p *progress) report(now time.Time) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var total int64
+	for _, fp := range p.files {
+		rows, read := atomic.LoadInt64(&fp.rows), atomic.LoadInt64(&fp.read)
+		total += rows
+		bytesRead.WithLabelValues(fp.name).Set(float64(read))
+		if fp.done {
+			continue
+		}
+
+		elapsed := now.Sub(fp.start)
+		fields := map[string]interface{}{
+			"file":       fp.name,
+			"table":      fp.table,
+			"rows":       rows,
+			"rows_per_s": float64(rows) / elapsed.Seconds(),
+			"bytes_read": read,
+			"size":       fp.size,
+		}
+		text := fmt.Sprintf("%s: %d rows, %.0f rows/s, %d of %d bytes read", fp.name, rows, float64(rows)/elapsed.Seconds(), read, fp.size)
+		if fp.size > 0 && read > 0 {
+			done := float64(read) / float64(fp.size)
+			eta := time.Duration(float64(elapsed) * (1 - done) / done).Round(time.Second)
+			fields["percent"] = 100 * done
+			fields["eta_s"] = eta.Seconds()
+			text += fmt.Sprintf(" (%.0f%%). eta %v", 100*done, eta)
+		}
+		logEvent("file_progress", fields, text)
+	}
+	return total
+}
+
+func (p *progress) count() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var total int64
+	for _, fp := range p.files {
+		total += atomic.LoadInt64(&fp.rows)
+	}
+	return total
+}
+
+// Stop logs the final count and stops reporting.
+func (p *progress) Stop() {
+	close(p.stop)
+	<-p.stopped
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// rejectsTable holds the rows that were skipped because they could not be
+// loaded.
+const rejectsTable = `
+CREATE TABLE IF NOT EXISTS import_rejects (
+	FILE_NAME   varchar(255) NOT NULL,
+	LINE_NUMBER bigint NOT NULL,
+	RAW_TEXT    text,
+	ERROR       text
+);`
+
+// rowError describes a row that could not be loaded.
+type rowError struct {
+	file string
+	line int64
+	raw  string
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("%s line %d: %v", e.file, e.line, e.err)
+}
+
+// saveReject records a rejected row. Bytes of the raw text that are not
+// valid UTF-8, which would be refused by the database, are replaced.
+func saveReject(tx *sql.Tx, e *rowError) error {
+	_, err := tx.Exec("INSERT INTO import_rejects VALUES ($1, $2, $3, $4);", e.file, e.line, strings.ToValidUTF8(e.raw, "\uFFFD"), e.err.Error())
+	return err
+}
+
+// fileError attributes err to the named file, unless it already identifies
+// the row at fault.
+func fileError(name string, err error) error {
+	if _, ok := err.(*rowError); ok {
+		return err
+	}
+	return fmt.Errorf("%s: %v", name, err)
+}
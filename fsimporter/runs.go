@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+// runsTable records every import and delta, and what it was loaded from, so
+// that readers can tell which FactSet delivery a version holds. It lives
+// alongside fs_versions rather than in a version, so it outlasts pruning.
+const runsTable = `
+CREATE TABLE IF NOT EXISTS import_runs (
+	ID          serial PRIMARY KEY,
+	VERSION     varchar(255) NOT NULL,
+	KIND        varchar(16) NOT NULL,
+	SOURCE      text NOT NULL,
+	SEQUENCE    integer,
+	CHECKSUM    text NOT NULL,
+	STARTED_AT  timestamp NOT NULL,
+	FINISHED_AT timestamp,
+	ROW_COUNTS  jsonb,
+	STATUS      varchar(16) NOT NULL,
+	ERROR       text
+);`
+
+// sequenceNumber finds the FactSet sequence number in a delivery's name,
+// e.g. 1617 in edm_premium_full_1617.zip.
+var sequenceNumber = regexp.MustCompile(`_(?:full|delta)_(\d+)`)
+
+// sourceName is how a delivery is recorded in import_runs.
+func sourceName(edmPath string) string {
+	if edmPath == "-" {
+		return "stdin"
+	}
+	return filepath.Base(strings.TrimRight(edmPath, "/"))
+}
+
+// startRun records the start of loading edm into version. kind is full,
+// sample or delta.
+func startRun(db *sql.DB, version string, kind string, edmPath string, edm *edmFiles) (int64, error) {
+	if _, err := db.Exec(runsTable); err != nil {
+		return 0, err
+	}
+
+	source := sourceName(edmPath)
+	var sequence sql.NullInt64
+	if m := sequenceNumber.FindStringSubmatch(source); m != nil {
+		n, err := strconv.ParseInt(m[1], 10, 32)
+		sequence = sql.NullInt64{Int64: n, Valid: err == nil}
+	}
+
+	start := time.Now()
+	var checksum string
+	if edm.stdin != nil {
+		// recorded by finishRun, once the file has been read
+		log.Printf("%s will be checksummed as it is read\n", source)
+	} else {
+		var err error
+		if checksum, err = edm.checksum(); err != nil {
+			return 0, err
+		}
+		log.Printf("%s has checksum %s (took %v)\n", source, checksum, time.Now().Sub(start))
+	}
+
+	var id int64
+	err := db.QueryRow(`
+INSERT INTO import_runs (VERSION, KIND, SOURCE, SEQUENCE, CHECKSUM, STARTED_AT, STATUS)
+VALUES ($1, $2, $3, $4, $5, $6, 'running') RETURNING ID;`,
+		version, kind, source, sequence, checksum, start.UTC()).Scan(&id)
+	return id, err
+}
+
+// finishRun records the outcome of a run, with the number of rows then in
+// each of version's tables, and the checksum of a file streamed from stdin
+// if the run read all of it.
+func finishRun(db *sql.DB, id int64, version string, files []factset.File, edm *edmFiles, runErr error) error {
+	counts, err := tableCounts(db, version, files)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+
+	status, errText := "succeeded", sql.NullString{}
+	checksum := sql.NullString{String: edm.stdinChecksum(), Valid: edm.stdin != nil}
+	if runErr != nil {
+		status, errText = "failed", sql.NullString{String: runErr.Error(), Valid: true}
+		checksum.Valid = false
+	}
+	_, err = db.Exec("UPDATE import_runs SET FINISHED_AT = $1, ROW_COUNTS = $2, STATUS = $3, ERROR = $4, CHECKSUM = COALESCE($5, CHECKSUM) WHERE ID = $6;",
+		time.Now().UTC(), string(b), status, errText, checksum, id)
+	return err
+}
+
+// tableCounts counts the rows of each of the tables of files, and of
+// uuid_to_fsid, that exist in schema.
+func tableCounts(db *sql.DB, schema string, files []factset.File) (map[string]int64, error) {
+	tables := []string{"uuid_to_fsid"}
+	for _, ft := range files {
+		tables = append(tables, ft.Table)
+	}
+
+	counts := make(map[string]int64)
+	for _, table := range tables {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2);",
+			schema, strings.ToLower(table)).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		var n int64
+		if err := db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s.%s;", schema, table)).Scan(&n); err != nil {
+			return nil, err
+		}
+		counts[table] = n
+	}
+	return counts, nil
+}
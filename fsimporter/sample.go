@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// entityFilter is the set of FACTSET_ENTITY_IDs loaded by a sampled import.
+// Rows of files with a FACTSET_ENTITY_ID column are loaded only if theirs is
+// in the set. Files without one, such as the code lookups, are loaded whole.
+type entityFilter map[string]bool
+
+// column returns the index of the FACTSET_ENTITY_ID column in header, or -1
+// if the file has none, or if f is nil and every row is loaded.
+func (f entityFilter) column(header []string) int {
+	if f == nil {
+		return -1
+	}
+	return headerIndex(header, "FACTSET_ENTITY_ID")
+}
+
+func headerIndex(header []string, name string) int {
+	for i, h := range header {
+		if strings.EqualFold(h, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// selectEntities picks the entities a sampled import loads: those in fsids
+// and the first sample entities of edm_entity.txt, along with their parents
+// and ultimate parents so that the structure of the sample is complete.
+func selectEntities(edm *edmFiles, opts loadOptions, fsids []string, sample int) (entityFilter, error) {
+	if edm.stdin != nil {
+		// the entities would be picked by reading files that are then
+		// loaded, and a stream can only be read once
+		return nil, fmt.Errorf("--sample and --filter-fsid cannot be used with a file streamed from stdin")
+	}
+
+	f := make(entityFilter)
+	for _, fsid := range fsids {
+		if fsid = strings.ToUpper(strings.TrimSpace(fsid)); fsid != "" {
+			f[fsid] = true
+		}
+	}
+
+	if sample > 0 {
+		n := 0
+		err := scanEntities(edm, "edm_entity.txt", opts, func(fsid string, row []interface{}, header []string) bool {
+			f[fsid] = true
+			n++
+			return n < sample
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(f) == 0 {
+		return nil, fmt.Errorf("no entities to load")
+	}
+
+	// each pass adds the parents of the entities found by the last, until
+	// there are none left to add
+	for {
+		var parents []string
+		err := scanEntities(edm, "edm_entity_structure.txt", opts, func(fsid string, row []interface{}, header []string) bool {
+			if !f[fsid] {
+				return true
+			}
+			for _, col := range []string{"FACTSET_PARENT_ENTITY_ID", "FACTSET_ULTIMATE_PARENT_ENTITY_ID"} {
+				if i := headerIndex(header, col); i >= 0 {
+					if parent := row[i].(string); parent != "" && !f[parent] {
+						parents = append(parents, parent)
+					}
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(parents) == 0 {
+			break
+		}
+		for _, parent := range parents {
+			f[parent] = true
+		}
+	}
+
+	log.Printf("loading a sample of %d entities\n", len(f))
+	return f, nil
+}
+
+// scanEntities calls fn with the FACTSET_ENTITY_ID of each row of the named
+// file until it returns false. A delivery without the file is not an error.
+func scanEntities(edm *edmFiles, name string, opts loadOptions, fn func(fsid string, row []interface{}, header []string) bool) error {
+	for _, file := range edm.files {
+		if file.name != name {
+			continue
+		}
+
+		var read int64
+		scanner, rc, err := openScanner(file, &read, opts.encoding)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		header := scanner.Header()
+		col := headerIndex(header, "FACTSET_ENTITY_ID")
+		if col < 0 {
+			return fmt.Errorf("%s: no FACTSET_ENTITY_ID column", name)
+		}
+		for scanner.Scan() {
+			row, err := scanner.Row()
+			if err != nil {
+				// bad rows are reported when the file is loaded
+				continue
+			}
+			if !fn(row[col].(string), row, header) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+	return nil
+}
+
+// fsidList expands the values of --filter-fsid, each a comma separated list
+// of FACTSET_ENTITY_IDs or @FILE for a file of them, one per line.
+func fsidList(specs []string) ([]string, error) {
+	var fsids []string
+	for _, spec := range specs {
+		if !strings.HasPrefix(spec, "@") {
+			fsids = append(fsids, strings.Split(spec, ",")...)
+			continue
+		}
+		f, err := os.Open(spec[1:])
+		if err != nil {
+			return nil, err
+		}
+		lines := bufio.NewScanner(f)
+		for lines.Scan() {
+			fsids = append(fsids, lines.Text())
+		}
+		err = lines.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fsids, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// scanner reads the records of a FactSet flat file.
+//
+// Fields are separated by '|' and may be enclosed in double quotes, in
+// which case they can contain '|', line breaks and doubled quotes ("")
+// standing for a single quote. Records end with LF or CRLF. The first
+// record is the header naming the columns; every following record must
+// have the same number of fields. There is no limit on record length.
+type scanner struct {
+	r      *bufio.Reader
+	header []string
+
+	line   int64 // line the current record starts on
+	next   int64 // line the next record starts on
+	raw    []byte
+	fields []string
+	rowErr error
+	err    error
+}
+
+func NewScanner(r io.Reader) (*scanner, error) {
+	s := &scanner{r: bufio.NewReader(r), next: 1}
+
+	// first line is field names
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty factset file")
+	}
+	if s.rowErr != nil || s.fields[0] == "" {
+		return nil, errors.New("unexpected factset file format")
+	}
+	s.header = append([]string(nil), s.fields...)
+
+	return s, nil
+}
+
+// Header returns the column names given on the first line.
+func (s *scanner) Header() []string {
+	return s.header
+}
+
+// Scan advances to the next record, returning false at the end of the
+// input or on a read error.
+func (s *scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	s.raw = s.raw[:0]
+	s.fields = s.fields[:0]
+	s.rowErr = nil
+	s.line = s.next
+
+	var field []byte
+	quoted := false    // inside a quoted field
+	wasQuoted := false // the current field started with a quote
+	started := false   // anything has been read for the current field
+
+	endField := func() {
+		s.fields = append(s.fields, string(field))
+		field = field[:0]
+		wasQuoted, started = false, false
+	}
+
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+				return false
+			}
+			if len(s.raw) == 0 {
+				return false
+			}
+			if quoted {
+				s.rowErr = errors.New("unterminated quoted field")
+			}
+			endField()
+			return true
+		}
+
+		if b == '\n' {
+			s.next++
+		}
+
+		switch {
+		case quoted:
+			s.raw = append(s.raw, b)
+			if b != '"' {
+				field = append(field, b)
+			} else if next, _ := s.r.Peek(1); len(next) == 1 && next[0] == '"' {
+				s.r.ReadByte()
+				s.raw = append(s.raw, '"')
+				field = append(field, '"')
+			} else {
+				quoted = false
+			}
+
+		case b == '\r':
+			if next, _ := s.r.Peek(1); len(next) == 1 && next[0] == '\n' {
+				// part of a CRLF line ending
+				continue
+			}
+			s.raw = append(s.raw, b)
+			field = append(field, b)
+			started = true
+
+		case b == '\n':
+			if len(s.raw) == 0 {
+				// skip blank lines
+				s.line = s.next
+				continue
+			}
+			endField()
+			return true
+
+		case b == '"' && !started:
+			s.raw = append(s.raw, b)
+			quoted, wasQuoted, started = true, true, true
+
+		case b == '|':
+			s.raw = append(s.raw, b)
+			endField()
+
+		default:
+			s.raw = append(s.raw, b)
+			if wasQuoted && s.rowErr == nil {
+				s.rowErr = fmt.Errorf("unexpected %q after quoted field", b)
+			}
+			field = append(field, b)
+			started = true
+		}
+	}
+}
+
+// Line returns the line number, counting the header as line 1, on which the
+// most recent record starts.
+func (s *scanner) Line() int64 {
+	return s.line
+}
+
+// Text returns the raw text of the most recent record, without its line
+// ending.
+func (s *scanner) Text() string {
+	return string(s.raw)
+}
+
+// Row returns the fields of the most recent record, normalised to NFC, or
+// an error if it is malformed, does not have one field per header column,
+// or holds bytes that were not valid in the file's encoding.
+func (s *scanner) Row() ([]interface{}, error) {
+	if s.rowErr != nil {
+		return nil, s.rowErr
+	}
+	if s.header != nil && len(s.fields) != len(s.header) {
+		return nil, fmt.Errorf("expected %d columns but found %d", len(s.header), len(s.fields))
+	}
+	row := make([]interface{}, len(s.fields))
+	for i, val := range s.fields {
+		if at := invalidText(val); at >= 0 {
+			col := fmt.Sprint("column ", i+1)
+			if s.header != nil {
+				col = s.header[i]
+			}
+			return nil, fmt.Errorf("%s: invalid byte at offset %d", col, at)
+		}
+		row[i] = norm.NFC.String(val)
+	}
+	return row, nil
+}
+
+// invalidText returns the offset of the first byte of s that is not valid
+// UTF-8, or that a decoder replaced with U+FFFD, or -1 if there is none.
+func invalidText(s string) int {
+	for i, r := range s {
+		if r == utf8.RuneError {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *scanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// scannerSeeds are files exercising the scanner's corner cases, each also
+// used by FuzzScanner as a field value.
+var scannerSeeds = []string{
+	"A|B\nx|y\n",
+	"A|B\n\"a|b\"|c\n",
+	"A|B\n\"say \"\"hi\"\"\"|c\n",
+	"A|B\r\nx|y\r\nz|w\r\n",
+	"A|B\n\"two\nlines\"|c\n\"three\r\nmore\nlines\"|d\n",
+	"A|B\nx|" + strings.Repeat("z", 70*1024) + "\n",
+	"A|B\n\"" + strings.Repeat("q|\"\"\n", 20*1024) + "\"|c\n",
+	"A|B\nx\nx|y|z\n",
+	"A|B\n\"unterminated|x\n",
+	"A|B\n\"a\"b|c\n",
+	"A|B\n\nx|y\n\n\n",
+	"A|B\nx|y",
+	"A|B\nx|\r\r\n",
+}
+
+// FuzzScanner checks that the scanner survives any input, that rows it
+// accepts have one field per column, and that any value written as a quoted
+// field is read back unchanged, with the lines of the records after it
+// counted correctly.
+func FuzzScanner(f *testing.F) {
+	for _, seed := range scannerSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		if s, err := NewScanner(strings.NewReader(data)); err == nil {
+			line := int64(1)
+			for s.Scan() {
+				if s.Line() <= line {
+					t.Fatalf("record on line %d follows line %d", s.Line(), line)
+				}
+				line = s.Line()
+				if row, err := s.Row(); err == nil && len(row) != len(s.Header()) {
+					t.Fatalf("accepted a row of %d fields for %d columns", len(row), len(s.Header()))
+				}
+			}
+			if err := s.Err(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		quoted := `"` + strings.Replace(data, `"`, `""`, -1) + `"`
+		s, err := NewScanner(strings.NewReader("A|B\nx|" + quoted + "\r\ny|z\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s.Scan() {
+			t.Fatalf("no record: %v", s.Err())
+		}
+		if s.rowErr != nil {
+			t.Fatalf("quoted field rejected: %v", s.rowErr)
+		}
+		if len(s.fields) != 2 || s.fields[0] != "x" || s.fields[1] != data {
+			t.Fatalf("read back %q", s.fields)
+		}
+		if s.Text() != "x|"+quoted {
+			t.Fatalf("raw text %q", s.Text())
+		}
+		if !s.Scan() {
+			t.Fatalf("no second record: %v", s.Err())
+		}
+		if want := 3 + int64(strings.Count(data, "\n")); s.Line() != want {
+			t.Fatalf("second record on line %d, not %d", s.Line(), want)
+		}
+		if s.Scan() {
+			t.Fatalf("unexpected record %q", s.Text())
+		}
+	})
+}
+
+// BenchmarkScanner reads a file of entity-like records, some with quoted
+// fields, and reports its throughput.
+func BenchmarkScanner(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("FACTSET_ENTITY_ID|ENTITY_NAME|ENTITY_PROPER_NAME|ISO_COUNTRY|WEB_SITE\r\n")
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("Entity %d Holdings", i)
+		if i%10 == 0 {
+			name = fmt.Sprintf("\"Entity %d | \"\"Holdings\"\"\"", i)
+		}
+		fmt.Fprintf(&buf, "%06X-E|%s|Entity %d Holdings Ltd|GB|https://example.com/%d\r\n", i, name, i, i)
+	}
+	data := buf.Bytes()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, err := NewScanner(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for s.Scan() {
+			if _, err := s.Row(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := s.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
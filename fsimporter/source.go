@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// edmFile is one file of a FactSet delivery, wherever it was found.
+type edmFile struct {
+	name   string // the name it is mapped by, e.g. edm_entity.txt
+	source string // the archive or file it came from
+	size   int64  // bytes stored, before any gunzipping
+	// open returns the file's content, adding the number of stored bytes
+	// read to *read as it goes unless read is nil.
+	open func(read *int64) (io.ReadCloser, error)
+}
+
+// edmFiles holds the files of a delivery and whatever has to be closed once
+// they have been read.
+type edmFiles struct {
+	files   []edmFile
+	paths   []string // the archives and files the delivery is made of
+	closers []func() error
+	// stdin hashes a file streamed from stdin as it is read, as it cannot
+	// be hashed beforehand.
+	stdin hash.Hash
+}
+
+func (e *edmFiles) Close() error {
+	var first error
+	for _, c := range e.closers {
+		if err := c(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openEDM finds the files of a FactSet delivery. edmPath may be a zip
+// archive, a directory, a glob matching archives and files, or "-" for stdin,
+// which holds a zip archive or the single file stdinName. Any file, in or
+// out of an archive, may be gzipped, in which case it is mapped by its name
+// without the .gz.
+func openEDM(edmPath string, stdinName string) (*edmFiles, error) {
+	e := &edmFiles{}
+
+	var err error
+	switch {
+	case edmPath == "-":
+		err = e.addStdin(stdinName)
+	case strings.ContainsAny(edmPath, "*?["):
+		var paths []string
+		if paths, err = filepath.Glob(edmPath); err == nil && len(paths) == 0 {
+			err = fmt.Errorf("nothing matches %s", edmPath)
+		}
+		for _, p := range paths {
+			if err = e.addPath(p); err != nil {
+				break
+			}
+		}
+	default:
+		err = e.addPath(edmPath)
+	}
+	if err == nil {
+		err = e.checkDuplicates()
+	}
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *edmFiles) addPath(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return e.addFile(p)
+	}
+
+	entries, err := ioutil.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			if err := e.addFile(filepath.Join(p, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *edmFiles) addFile(p string) error {
+	if strings.HasSuffix(strings.ToLower(p), ".zip") {
+		return e.addZip(p, filepath.Base(p))
+	}
+	e.paths = append(e.paths, p)
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	e.add(filepath.Base(p), filepath.Base(p), info.Size(), func() (io.ReadCloser, error) { return os.Open(p) })
+	return nil
+}
+
+func (e *edmFiles) addZip(p string, source string) error {
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	e.paths = append(e.paths, p)
+	e.closers = append(e.closers, r.Close)
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		e.add(path.Base(f.Name), source, int64(f.UncompressedSize64), f.Open)
+	}
+	return nil
+}
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// addStdin reads the delivery on stdin. A zip archive is copied to a
+// temporary file, as its directory is at the end and so it cannot be read
+// as a stream. Anything else is taken to be the single file stdinName,
+// gzipped or not by its content, which is streamed as it is loaded and so
+// can only be read once.
+func (e *edmFiles) addStdin(stdinName string) error {
+	in := bufio.NewReader(os.Stdin)
+	start, err := in.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read stdin: %v", err)
+	}
+	if bytes.HasPrefix(start, zipMagic) {
+		return e.spoolZip(in)
+	}
+
+	if stdinName == "" {
+		return errors.New("stdin is not a zip archive, so --stdin-name must name the file it holds")
+	}
+	name := stdinName
+	if strings.HasSuffix(strings.ToLower(name), ".gz") {
+		name = name[:len(name)-len(".gz")]
+	}
+	if bytes.HasPrefix(start, gzipMagic) {
+		name += ".gz"
+	}
+
+	e.stdin = sha256.New()
+	var opened int32
+	e.add(name, "stdin", 0, func() (io.ReadCloser, error) {
+		if !atomic.CompareAndSwapInt32(&opened, 0, 1) {
+			return nil, fmt.Errorf("%s is streamed from stdin, so it can only be read once", stdinName)
+		}
+		return ioutil.NopCloser(io.TeeReader(in, e.stdin)), nil
+	})
+	return nil
+}
+
+// stdinChecksum returns the SHA-256 of a file streamed from stdin, once it
+// has been read, or "" if none was.
+func (e *edmFiles) stdinChecksum() string {
+	if e.stdin == nil {
+		return ""
+	}
+	return "sha256:" + hex.EncodeToString(e.stdin.Sum(nil))
+}
+
+// spoolZip copies the zip archive read by in to a temporary file and adds
+// its files.
+func (e *edmFiles) spoolZip(in io.Reader) error {
+	tmp, err := ioutil.TempFile("", "fsimporter")
+	if err != nil {
+		return err
+	}
+	e.closers = append(e.closers, func() error { return os.Remove(tmp.Name()) })
+
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %v", err)
+	}
+	return e.addZip(tmp.Name(), "stdin")
+}
+
+// add records a file, unwrapping it on opening if it is gzipped.
+func (e *edmFiles) add(name string, source string, size int64, stored func() (io.ReadCloser, error)) {
+	gzipped := strings.HasSuffix(strings.ToLower(name), ".gz")
+	if gzipped {
+		name = name[:len(name)-len(".gz")]
+	}
+	open := func(read *int64) (io.ReadCloser, error) {
+		rc, err := stored()
+		if err != nil {
+			return nil, err
+		}
+		if read != nil {
+			rc = countingReadCloser{rc, read}
+		}
+		if !gzipped {
+			return rc, nil
+		}
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return gzipReadCloser{gz, rc}, nil
+	}
+	e.files = append(e.files, edmFile{name: name, source: source, size: size, open: open})
+}
+
+func (e *edmFiles) checkDuplicates() error {
+	sort.SliceStable(e.files, func(i, j int) bool { return e.files[i].name < e.files[j].name })
+	for i := 1; i < len(e.files); i++ {
+		if e.files[i].name == e.files[i-1].name {
+			return fmt.Errorf("%s found in both %s and %s", e.files[i].name, e.files[i-1].source, e.files[i].source)
+		}
+	}
+	return nil
+}
+
+// checksum returns the SHA-256 of the archives and files of the delivery,
+// taken in turn. A file streamed from stdin is not among them, as it can only
+// be read once; its checksum is taken by stdinChecksum as it is loaded.
+func (e *edmFiles) checksum() (string, error) {
+	h := sha256.New()
+	for _, p := range e.paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countingReadCloser adds the number of bytes read to *n.
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (c countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// gzipReadCloser closes both the gzip reader and what it reads from.
+type gzipReadCloser struct {
+	*gzip.Reader
+	underlying io.Closer
+}
+
+func (g gzipReadCloser) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.underlying.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
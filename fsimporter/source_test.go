@@ -0,0 +1,338 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, p string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipped returns a zip archive of the given files, named and written in
+// turn.
+func zipped(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		w, err := z.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withStdin runs f with os.Stdin reading content.
+func withStdin(t *testing.T, content []byte, f func()) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "stdin")
+	writeFile(t, p, content)
+	in, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	stdin := os.Stdin
+	os.Stdin = in
+	defer func() { os.Stdin = stdin }()
+	f()
+}
+
+func TestOpenEDM(t *testing.T) {
+	const entity = "FACTSET_ENTITY_ID|ENTITY_NAME\n000C7F-E|Foo\n"
+	const names = "FACTSET_ENTITY_ID|ENTITY_NAME_TYPE|ENTITY_NAME_VALUE\n000C7F-E|SHORT_NAME|Foo\n"
+
+	tests := []struct {
+		name string
+		// setup creates the delivery in dir and returns the EDMPATH to
+		// open it by.
+		setup func(t *testing.T, dir string) string
+		// stdin returns what stdin holds.
+		stdin     func(t *testing.T) []byte
+		stdinName string
+		// want maps each file found to its source and content.
+		want    map[string][2]string
+		wantErr string
+	}{
+		{
+			name: "zip",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "edm_full_1617.zip"), zipped(t, "edm_entity.txt", entity, "sub/edm_entity_names.txt", names))
+				return filepath.Join(dir, "edm_full_1617.zip")
+			},
+			want: map[string][2]string{
+				"edm_entity.txt":       {"edm_full_1617.zip", entity},
+				"edm_entity_names.txt": {"edm_full_1617.zip", names},
+			},
+		},
+		{
+			name: "gzipped file in a zip",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "d.zip"), zipped(t, "edm_entity.txt.gz", string(gzipped(t, entity))))
+				return filepath.Join(dir, "d.zip")
+			},
+			want: map[string][2]string{"edm_entity.txt": {"d.zip", entity}},
+		},
+		{
+			name: "directory of plain and gzipped files",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "d", "edm_entity.txt"), []byte(entity))
+				writeFile(t, filepath.Join(dir, "d", "edm_entity_names.TXT.GZ"), gzipped(t, names))
+				writeFile(t, filepath.Join(dir, "d", "nested", "ignored.txt"), []byte("x"))
+				return filepath.Join(dir, "d")
+			},
+			want: map[string][2]string{
+				"edm_entity.txt":       {"edm_entity.txt", entity},
+				"edm_entity_names.TXT": {"edm_entity_names.TXT.GZ", names},
+			},
+		},
+		{
+			name: "directory holding a zip",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "d", "part1.ZIP"), zipped(t, "edm_entity.txt", entity))
+				writeFile(t, filepath.Join(dir, "d", "edm_entity_names.txt"), []byte(names))
+				return filepath.Join(dir, "d")
+			},
+			want: map[string][2]string{
+				"edm_entity.txt":       {"part1.ZIP", entity},
+				"edm_entity_names.txt": {"edm_entity_names.txt", names},
+			},
+		},
+		{
+			name: "glob of split archives",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "edm_full_1617_1.zip"), zipped(t, "edm_entity.txt", entity))
+				writeFile(t, filepath.Join(dir, "edm_full_1617_2.zip"), zipped(t, "edm_entity_names.txt", names))
+				writeFile(t, filepath.Join(dir, "edm_full_1618_1.zip"), zipped(t, "edm_entity.txt", "other"))
+				return filepath.Join(dir, "edm_full_1617_*.zip")
+			},
+			want: map[string][2]string{
+				"edm_entity.txt":       {"edm_full_1617_1.zip", entity},
+				"edm_entity_names.txt": {"edm_full_1617_2.zip", names},
+			},
+		},
+		{
+			name: "glob matching nothing",
+			setup: func(t *testing.T, dir string) string {
+				return filepath.Join(dir, "*.zip")
+			},
+			wantErr: "nothing matches",
+		},
+		{
+			name: "missing path",
+			setup: func(t *testing.T, dir string) string {
+				return filepath.Join(dir, "missing.zip")
+			},
+			wantErr: "no such file",
+		},
+		{
+			name: "same file in two archives",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "a.zip"), zipped(t, "edm_entity.txt", entity))
+				writeFile(t, filepath.Join(dir, "b.zip"), zipped(t, "edm_entity.txt", entity))
+				return filepath.Join(dir, "*.zip")
+			},
+			wantErr: "edm_entity.txt found in both a.zip and b.zip",
+		},
+		{
+			name: "same file plain and gzipped",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "d", "edm_entity.txt"), []byte(entity))
+				writeFile(t, filepath.Join(dir, "d", "edm_entity.txt.gz"), gzipped(t, entity))
+				return filepath.Join(dir, "d")
+			},
+			wantErr: "edm_entity.txt found in both",
+		},
+		{
+			name:  "zip on stdin",
+			setup: func(t *testing.T, dir string) string { return "-" },
+			stdin: func(t *testing.T) []byte { return zipped(t, "edm_entity.txt", entity) },
+			want:  map[string][2]string{"edm_entity.txt": {"stdin", entity}},
+		},
+		{
+			name:      "plain file on stdin",
+			setup:     func(t *testing.T, dir string) string { return "-" },
+			stdin:     func(t *testing.T) []byte { return []byte(entity) },
+			stdinName: "edm_entity.txt",
+			want:      map[string][2]string{"edm_entity.txt": {"stdin", entity}},
+		},
+		{
+			name:      "gzipped file on stdin",
+			setup:     func(t *testing.T, dir string) string { return "-" },
+			stdin:     func(t *testing.T) []byte { return gzipped(t, entity) },
+			stdinName: "edm_entity.txt.gz",
+			want:      map[string][2]string{"edm_entity.txt": {"stdin", entity}},
+		},
+		{
+			name:      "gzipped file on stdin named without .gz",
+			setup:     func(t *testing.T, dir string) string { return "-" },
+			stdin:     func(t *testing.T) []byte { return gzipped(t, entity) },
+			stdinName: "edm_entity.txt",
+			want:      map[string][2]string{"edm_entity.txt": {"stdin", entity}},
+		},
+		{
+			name:    "unnamed file on stdin",
+			setup:   func(t *testing.T, dir string) string { return "-" },
+			stdin:   func(t *testing.T) []byte { return []byte(entity) },
+			wantErr: "--stdin-name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdin []byte
+			if tt.stdin != nil {
+				stdin = tt.stdin(t)
+			}
+
+			edmPath := tt.setup(t, t.TempDir())
+			withStdin(t, stdin, func() {
+				edm, err := openEDM(edmPath, tt.stdinName)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer edm.Close()
+
+				got := make(map[string][2]string)
+				for _, f := range edm.files {
+					var read int64
+					rc, err := f.open(&read)
+					if err != nil {
+						t.Fatalf("%s: %v", f.name, err)
+					}
+					b, err := ioutil.ReadAll(rc)
+					rc.Close()
+					if err != nil {
+						t.Fatalf("%s: %v", f.name, err)
+					}
+					if read == 0 {
+						t.Errorf("%s: no bytes counted as read", f.name)
+					}
+					got[f.name] = [2]string{f.source, string(b)}
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("found %v, want %v", keys(got), keys(tt.want))
+				}
+				for name, want := range tt.want {
+					if got[name] != want {
+						t.Errorf("%s: got source %q and content %q, want %q and %q", name, got[name][0], got[name][1], want[0], want[1])
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestStdinStreamReadOnce(t *testing.T) {
+	const entity = "FACTSET_ENTITY_ID|ENTITY_NAME\n000C7F-E|Foo\n"
+	withStdin(t, []byte(entity), func() {
+		edm, err := openEDM("-", "edm_entity.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer edm.Close()
+
+		if len(edm.paths) != 0 {
+			t.Errorf("a stream has no paths to checksum, got %v", edm.paths)
+		}
+		rc, err := edm.files[0].open(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(rc); err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+
+		sum := sha256.Sum256([]byte(entity))
+		if got, want := edm.stdinChecksum(), "sha256:"+hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("checksum %s, want %s", got, want)
+		}
+
+		if _, err := edm.files[0].open(nil); err == nil || !strings.Contains(err.Error(), "only be read once") {
+			t.Errorf("second open gave %v", err)
+		}
+	})
+}
+
+func TestZipOnStdinIsSpooled(t *testing.T) {
+	withStdin(t, zipped(t, "edm_entity.txt", "A\n"), func() {
+		edm, err := openEDM("-", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(edm.paths) != 1 || edm.stdin != nil {
+			t.Fatalf("got paths %v, streamed %v", edm.paths, edm.stdin != nil)
+		}
+		spool := edm.paths[0]
+		if _, err := os.Stat(spool); err != nil {
+			t.Fatal(err)
+		}
+		// a spooled archive can be read as often as needed
+		for i := 0; i < 2; i++ {
+			rc, err := edm.files[0].open(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc.Close()
+		}
+		if err := edm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(spool); !os.IsNotExist(err) {
+			t.Errorf("spool %s left behind: %v", spool, err)
+		}
+	})
+}
+
+func keys(m map[string][2]string) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
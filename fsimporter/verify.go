@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jawher/mow.cli"
+
+	"github.com/Financial-Times/fs-sql-spike/dbconn"
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+func verifyCmd(cmd *cli.Cmd) {
+	conf := dbconn.Options(cmd)
+
+	edmPath := cmd.String(cli.StringArg{
+		Name:   "EDMPATH",
+		Desc:   "the FactSet delivery the version was loaded from",
+		EnvVar: "FSIMPORT_EDM_PATH",
+	})
+
+	dbName := cmd.String(cli.StringArg{
+		Name:   "DBNAME",
+		Desc:   "database schema name",
+		EnvVar: "FSIMPORT_DB_NAME",
+	})
+
+	schema := schemaOpt(cmd, "version to verify")
+
+	mapping := mappingOpt(cmd)
+	encoding := encodingOpt(cmd)
+	stdinName := stdinNameOpt(cmd)
+
+	cmd.Action = func() {
+		if err := checkEncoding(*encoding); err != nil {
+			log.Fatal(err)
+		}
+		opts := loadOptions{files: fileMappings(*mapping), encoding: *encoding, stdinName: *stdinName}
+		report := runVerify(conf, *edmPath, *dbName, *schema, opts)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		if !report.OK {
+			cli.Exit(1)
+		}
+	}
+}
+
+// verifyReport is the outcome of verify, written as JSON.
+type verifyReport struct {
+	Database string        `json:"database"`
+	Schema   string        `json:"schema"`
+	OK       bool          `json:"ok"`
+	Checks   []verifyCheck `json:"checks"`
+}
+
+// verifyCheck is the result of one check. Expected and Found are counts
+// whose meaning depends on the check.
+type verifyCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Expected int64  `json:"expected"`
+	Found    int64  `json:"found"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (r *verifyReport) add(c verifyCheck) {
+	r.Checks = append(r.Checks, c)
+	if !c.OK {
+		r.OK = false
+	}
+}
+
+// runVerify checks the loaded tables against the files of edmPath and
+// against each other:
+//
+//   - each file's record count matches its table's rows plus its rejects
+//   - every FACTSET_ENTITY_ID, and every parent in fsStructure, is in fsEntity
+//   - uuid_to_fsid maps exactly the entities in fsEntity
+//   - no unique key, FACTSET_ENTITY_ID among them, appears more than once
+//
+// The counts only reconcile for a full import; deltas change them.
+func runVerify(conf *dbconn.Config, edmPath string, dbName string, schema string, opts loadOptions) *verifyReport {
+	files := opts.files
+	adminDB, _, schema := openSchema(conf, dbName, schema)
+	adminDB.Close()
+
+	db, err := conf.Open(dbName, schema)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	report := &verifyReport{Database: dbName, Schema: schema, OK: true}
+
+	tables := make(map[string]bool)
+	for _, ft := range files {
+		exists, err := tableExists(db, ft.Table)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tables[ft.Table] = exists
+	}
+
+	for _, c := range verifyCounts(db, edmPath, opts, tables) {
+		report.add(c)
+	}
+
+	if !tables["fsEntity"] {
+		report.add(verifyCheck{Name: "fsEntity exists", Detail: "no fsEntity table"})
+		return report
+	}
+	for _, ft := range files {
+		if !tables[ft.Table] || ft.Table == "fsEntity" {
+			continue
+		}
+		cols, err := tableColumns(db, ft.Table)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, col := range cols {
+			if col.name == "factset_entity_id" || (ft.Table == "fsStructure" && strings.HasSuffix(col.name, "parent_entity_id")) {
+				report.add(countCheck(db, fmt.Sprintf("%s.%s in fsEntity", ft.Table, col.name), fmt.Sprintf(`
+SELECT count(*) FROM %s t WHERE t.%s IS NOT NULL AND NOT EXISTS (SELECT 1 FROM fsEntity e WHERE e.FACTSET_ENTITY_ID = t.%s);`, ft.Table, col.name, col.name)))
+			}
+		}
+	}
+
+	report.add(countCheck(db, "fsEntity in uuid_to_fsid", `
+SELECT count(*) FROM fsEntity e WHERE NOT EXISTS (SELECT 1 FROM uuid_to_fsid u WHERE u.FACTSET_ENTITY_ID = e.FACTSET_ENTITY_ID);`))
+	report.add(countCheck(db, "uuid_to_fsid in fsEntity", `
+SELECT count(*) FROM uuid_to_fsid u WHERE NOT EXISTS (SELECT 1 FROM fsEntity e WHERE e.FACTSET_ENTITY_ID = u.FACTSET_ENTITY_ID);`))
+	report.add(countCheck(db, "uuid_to_fsid.UUID unique", `
+SELECT count(*) FROM (SELECT UUID FROM uuid_to_fsid GROUP BY UUID HAVING count(*) > 1) d;`))
+
+	for _, ft := range files {
+		if !tables[ft.Table] || !ft.Unique {
+			continue
+		}
+		key := strings.Join(ft.Key, ", ")
+		report.add(countCheck(db, fmt.Sprintf("%s.%s unique", ft.Table, strings.Join(ft.Key, "+")), fmt.Sprintf(`
+SELECT count(*) FROM (SELECT %s FROM %s GROUP BY %s HAVING count(*) > 1) d;`, key, ft.Table, key)))
+	}
+
+	return report
+}
+
+// countCheck runs a query counting offending rows, which should find none.
+func countCheck(db *sql.DB, name string, query string) verifyCheck {
+	c := verifyCheck{Name: name}
+	if err := db.QueryRow(query).Scan(&c.Found); err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.OK = c.Found == 0
+	return c
+}
+
+// verifyCounts compares the number of records in each mapped file of
+// edmPath with the rows of its table plus the rows rejected from it.
+func verifyCounts(db *sql.DB, edmPath string, opts loadOptions, tables map[string]bool) []verifyCheck {
+	edm, err := openEDM(edmPath, opts.stdinName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer edm.Close()
+
+	hasRejects, err := tableExists(db, "import_rejects")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var mapped []fileJob
+	for _, file := range edm.files {
+		if ft, ok := opts.file(file.name); ok {
+			mapped = append(mapped, fileJob{file: file, ft: ft})
+		}
+	}
+
+	checks := make([]verifyCheck, len(mapped))
+	wg := sync.WaitGroup{}
+	for i, job := range mapped {
+		wg.Add(1)
+		go func(c *verifyCheck, job fileJob) {
+			defer wg.Done()
+			c.Name = fmt.Sprintf("%s rows in %s", job.file.name, job.ft.Table)
+			if err := countFile(db, job.file, job.ft, opts.encoding, tables[job.ft.Table], hasRejects, c); err != nil {
+				c.Detail = err.Error()
+			}
+		}(&checks[i], job)
+	}
+	wg.Wait()
+	return checks
+}
+
+func countFile(db *sql.DB, file edmFile, ft factset.File, encoding string, hasTable bool, hasRejects bool, c *verifyCheck) error {
+	if !hasTable {
+		return fmt.Errorf("no %s table", ft.Table)
+	}
+
+	scanner, rc, err := openScanner(file, nil, encoding)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	for scanner.Scan() {
+		c.Expected++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s;", ft.Table)).Scan(&c.Found); err != nil {
+		return err
+	}
+	if hasRejects {
+		var rejected int64
+		if err := db.QueryRow("SELECT count(*) FROM import_rejects WHERE FILE_NAME = $1;", file.name).Scan(&rejected); err != nil {
+			return err
+		}
+		if rejected > 0 {
+			c.Found += rejected
+			c.Detail = fmt.Sprintf("including %d rejected rows", rejected)
+		}
+	}
+	c.OK = c.Found == c.Expected
+	return nil
+}
+
+// tableExists reports whether table is in the current schema.
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1);", strings.ToLower(table)).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
+)
+
+// Each import is loaded into its own schema (a version) within the
+// database. Readers such as org-transformer use the alias schema, which
+// holds one view per table of the current version, so switching versions
+// is a single transaction that replaces those views.
+const (
+	aliasSchema   = "fs"
+	versionPrefix = "fs_"
+)
+
+const versionsTable = `
+CREATE TABLE IF NOT EXISTS fs_versions (
+	VERSION      varchar(255) PRIMARY KEY,
+	CREATED_AT   timestamp NOT NULL,
+	ACTIVATED_AT timestamp
+);`
+
+func createVersionsTable(db *sql.DB) error {
+	_, err := db.Exec(versionsTable)
+	return err
+}
+
+// createVersion creates an empty schema for a new import and records it in
+// fs_versions. The version does not become current until activateVersion is
+// called.
+func createVersion(db *sql.DB) (string, error) {
+	if err := createVersionsTable(db); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	version := versionPrefix + now.Format("20060102150405")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("CREATE SCHEMA %s;", version)); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("INSERT INTO fs_versions (VERSION, CREATED_AT) VALUES ($1, $2);", version, now); err != nil {
+		return "", err
+	}
+	return version, tx.Commit()
+}
+
+// incompleteVersion returns the most recently created version if it was
+// never made current, so that its import can be resumed.
+func incompleteVersion(db *sql.DB) (string, error) {
+	if err := createVersionsTable(db); err != nil {
+		return "", err
+	}
+
+	var version string
+	var activated bool
+	err := db.QueryRow("SELECT VERSION, ACTIVATED_AT IS NOT NULL FROM fs_versions ORDER BY CREATED_AT DESC LIMIT 1;").Scan(&version, &activated)
+	if err == sql.ErrNoRows || (err == nil && activated) {
+		return "", errors.New("there is no incomplete import to resume")
+	}
+	return version, err
+}
+
+// validateLoad checks that a freshly loaded version is fit to be made
+// current: that the delivery held every built in file, so that part of one
+// is not mistaken for the whole, and that every entity was loaded with a
+// uuid mapping, along with their structure, names and identifiers. A sample
+// may have none of the last three.
+func validateLoad(db *sql.DB, edm *edmFiles, opts loadOptions) error {
+	present := make(map[string]bool)
+	for _, f := range edm.files {
+		present[f.name] = true
+	}
+	var missing []string
+	for _, f := range factset.Files {
+		if !present[f.Name] {
+			missing = append(missing, f.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the delivery has no %s", strings.Join(missing, ", "))
+	}
+
+	var entities, mapped int
+	if err := db.QueryRow("SELECT count(*) FROM fsEntity;").Scan(&entities); err != nil {
+		return err
+	}
+	if entities == 0 {
+		return errors.New("no entities were loaded")
+	}
+	if err := db.QueryRow("SELECT count(*) FROM uuid_to_fsid;").Scan(&mapped); err != nil {
+		return err
+	}
+	if mapped != entities {
+		return fmt.Errorf("%d entities but %d uuid mappings", entities, mapped)
+	}
+
+	if opts.entities != nil {
+		return nil
+	}
+	for _, table := range []string{"fsStructure", "fsNames", "fsIdentifiers"} {
+		var empty bool
+		if err := db.QueryRow(fmt.Sprintf("SELECT NOT EXISTS (SELECT 1 FROM %s);", table)).Scan(&empty); err != nil {
+			return err
+		}
+		if empty {
+			return fmt.Errorf("no rows were loaded into %s", table)
+		}
+	}
+	return nil
+}
+
+// activateVersion atomically repoints the alias schema at the given version.
+func activateVersion(db *sql.DB, version string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE fs_versions SET ACTIVATED_AT = $1 WHERE VERSION = $2;", time.Now().UTC(), version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("no such version %s", version)
+	}
+
+	if err := createAliasViews(tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// refreshAliasViews recreates the alias views of the current version, so
+// that they pick up tables or columns added to it.
+func refreshAliasViews(db *sql.DB, version string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createAliasViews(tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createAliasViews replaces the alias schema with one view per table of the
+// given version.
+func createAliasViews(tx *sql.Tx, version string) error {
+	rows, err := tx.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = $1;", version)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmts := []string{
+		fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE;", aliasSchema),
+		fmt.Sprintf("CREATE SCHEMA %s;", aliasSchema),
+	}
+	for _, table := range tables {
+		stmts = append(stmts, fmt.Sprintf("CREATE VIEW %s.%s AS SELECT * FROM %s.%s;", aliasSchema, table, version, table))
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentVersion returns the version the alias schema points at, or "" if
+// no version has been activated.
+func currentVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("SELECT VERSION FROM fs_versions WHERE ACTIVATED_AT IS NOT NULL ORDER BY ACTIVATED_AT DESC LIMIT 1;").Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return version, err
+}
+
+// previousVersion returns the most recent version created before the given
+// one that was made current in its time. Versions whose import failed or
+// never passed validation are skipped.
+func previousVersion(db *sql.DB, version string) (string, error) {
+	var previous string
+	err := db.QueryRow(`
+SELECT VERSION FROM fs_versions
+WHERE CREATED_AT < (SELECT CREATED_AT FROM fs_versions WHERE VERSION = $1)
+AND ACTIVATED_AT IS NOT NULL
+ORDER BY CREATED_AT DESC LIMIT 1;`, version).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no version before %s to roll back to", version)
+	}
+	return previous, err
+}
+
+// wasActivated reports whether version has ever been made current, which it
+// only is once its load has been validated.
+func wasActivated(db *sql.DB, version string) (bool, error) {
+	var activated bool
+	err := db.QueryRow("SELECT ACTIVATED_AT IS NOT NULL FROM fs_versions WHERE VERSION = $1;", version).Scan(&activated)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("no such version %s", version)
+	}
+	return activated, err
+}
+
+// pruneVersions drops the versions created before the current one that
+// were never made current, being failed imports, and all but the keep most
+// recent of those that were.
+func pruneVersions(db *sql.DB, keep int) error {
+	current, err := currentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	failed, err := queryVersions(db, `
+SELECT VERSION FROM fs_versions
+WHERE ACTIVATED_AT IS NULL AND CREATED_AT < (SELECT CREATED_AT FROM fs_versions WHERE VERSION = $1);`, current)
+	if err != nil {
+		return err
+	}
+	old, err := queryVersions(db, `
+SELECT VERSION FROM fs_versions
+WHERE VERSION <> $1 AND ACTIVATED_AT IS NOT NULL
+ORDER BY CREATED_AT DESC OFFSET $2;`, current, keep)
+	if err != nil {
+		return err
+	}
+	old = append(failed, old...)
+
+	for _, version := range old {
+		if _, err := db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE;", version)); err != nil {
+			return err
+		}
+		if _, err := db.Exec("DELETE FROM fs_versions WHERE VERSION = $1;", version); err != nil {
+			return err
+		}
+		log.Printf("dropped version %s\n", version)
+	}
+	return nil
+}
+
+func queryVersions(db *sql.DB, q string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
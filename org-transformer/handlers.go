@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
+	"log"
 	"net/http"
 )
 
@@ -12,7 +14,7 @@ type handlers struct {
 
 func (h handlers) idHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	o, found, err := h.theDB.getOrg(vars["uuid"])
+	j, found, err := h.theDB.orgJSON(vars["uuid"])
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -21,10 +23,6 @@ func (h handlers) idHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	j, err := json.Marshal(o)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
 	w.Write(j)
 	w.Write([]byte("\n"))
 }
@@ -54,3 +52,135 @@ func (h handlers) listHandler(w http.ResponseWriter, r *http.Request) {
 		return nil
 	})
 }
+
+// allHandler streams every org as newline delimited JSON, in UUID order. A
+// client that is cut off can carry on from the last org it received with
+// ?after=UUID.
+func (h handlers) allHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	err := h.theDB.forEachOrg(r.Context(), r.URL.Query().Get("after"), func(doc []byte) error {
+		if n == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		if _, err := w.Write(append(doc, '\n')); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%orgBatchSize == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		return
+	}
+	if n == 0 {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the status has been sent, so all that can be done is to stop
+	log.Printf("stopped streaming orgs after %d: %v\n", n, err)
+}
+
+// lookupHandler finds an org by an identifier other than its UUID: its
+// FactSet id (type factset) or any ENTITY_ID_TYPE, such as lei or cik. It
+// redirects to the org's canonical URL or, with ?redirect=false, returns the
+// org itself. An identifier shared by several orgs is answered with 300
+// Multiple Choices and their UUIDs.
+func (h handlers) lookupHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuids, err := h.theDB.resolveID(vars["type"], vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch len(uuids) {
+	case 0:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case 1:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultipleChoices)
+		json.NewEncoder(w).Encode(map[string][]string{"uuids": uuids})
+		return
+	}
+
+	if r.URL.Query().Get("redirect") != "false" {
+		http.Redirect(w, r, "/transformers/organisations/"+uuids[0], http.StatusFound)
+		return
+	}
+	j, found, err := h.theDB.orgJSON(uuids[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(j)
+	w.Write([]byte("\n"))
+}
+
+// maxBatchIDs is the most ids a batch lookup may ask for.
+const maxBatchIDs = 1000
+
+// batchHandler looks up the orgs with the UUIDs or FACTSET_ENTITY_IDs in the
+// JSON array posted to it, returning those found in the order they were
+// asked for, and listing the ids that matched none.
+func (h handlers) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		http.Error(w, "expected a JSON array of UUIDs or FactSet ids", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxBatchIDs {
+		http.Error(w, fmt.Sprintf("at most %d ids may be looked up at once", maxBatchIDs), http.StatusBadRequest)
+		return
+	}
+
+	docs, err := h.theDB.getOrgs(ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := batchResponse{Orgs: []json.RawMessage{}, Missing: []string{}}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if doc, ok := docs[id]; ok {
+			res.Orgs = append(res.Orgs, doc)
+		} else {
+			res.Missing = append(res.Missing, id)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, "failed to serialise orgs", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h handlers) buildInfoHandler(w http.ResponseWriter, r *http.Request) {
+	info, found, err := h.theDB.buildInfo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		http.Error(w, "failed to serialise build info", http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,29 +1,31 @@
 package main
 
-type org struct {
-	UUID                   string                 `json:"uuid"`
-	Type                   string                 `json:"type"`
-	ProperName             string                 `json:"properName"`
-	PrefLabel              string                 `json:"prefLabel"`
-	LegalName              string                 `json:"legalName,omitempty"`
-	ShortName              string                 `json:"shortName,omitempty"`
-	HiddenLabel            string                 `json:"hiddenLabel,omitempty"`
-	TradeNames             []string               `json:"tradeNames,omitempty"`
-	LocalNames             []string               `json:"localNames,omitempty"`
-	FormerNames            []string               `json:"formerNames,omitempty"`
-	Aliases                []string               `json:"aliases,omitempty"`
-	IndustryClassification string                 `json:"industryClassification,omitempty"`
-	ParentOrganisation     string                 `json:"parentOrganisation,omitempty"`
-	AlternativeIdentifiers alternativeIdentifiers `json:"alternativeIdentifiers,omitempty"`
-	PostalCode             string                 `json:"postalCode,omitempty"`
-	CountryCode            string                 `json:"countryCode,omitempty"`
-	CountryOfIncorporation string                 `json:"countryOfIncorporation,omitempty"`
-	YearFounded            string                 `json:"yearFounded,omitempty"`
+import (
+	"encoding/json"
+	"time"
+)
+
+// batchResponse answers a batch lookup with the orgs found, in the order
+// they were asked for, and the ids that matched none.
+type batchResponse struct {
+	Orgs    []json.RawMessage `json:"orgs"`
+	Missing []string          `json:"missing"`
+}
+
+// buildInfo describes the FactSet deliveries the current data was loaded
+// from.
+type buildInfo struct {
+	Version string      `json:"version"`
+	Runs    []importRun `json:"runs"`
 }
 
-type alternativeIdentifiers struct {
-	TME               []string `json:"TME,omitempty"`
-	UUIDs             []string `json:"uuids,omitempty"`
-	FactsetIdentifier string   `json:"factsetIdentifier,omitempty"`
-	LeiCode           string   `json:"leiCode,omitempty"`
+type importRun struct {
+	Kind       string           `json:"kind"`
+	Source     string           `json:"source"`
+	Sequence   int64            `json:"sequence,omitempty"`
+	Checksum   string           `json:"checksum"`
+	StartedAt  time.Time        `json:"startedAt"`
+	FinishedAt *time.Time       `json:"finishedAt,omitempty"`
+	RowCounts  map[string]int64 `json:"rowCounts,omitempty"`
+	Status     string           `json:"status"`
 }
@@ -1,214 +1,249 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
 	"database/sql"
-	"log"
+	"encoding/json"
+	"fmt"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/pborman/uuid"
+
+	"github.com/Financial-Times/fs-sql-spike/factset"
 )
 
 type orgDB struct {
 	db *sql.DB
 }
 
-func (orgs *orgDB) getOrg(uuid string) (o org, found bool, err error) {
+// orgBatchSize is the number of orgs forEachOrg fetches from its cursor at
+// a time.
+const orgBatchSize = 1000
 
-	// uuid to fsid mapping
-	mapRows, err := orgs.db.Query(`SELECT * from uuid_to_fsid WHERE UUID = $1;`, uuid)
-	if err != nil {
-		return
+func (orgs *orgDB) getOrg(uuid string) (o factset.Org, found bool, err error) {
+	o, err = factset.ScanOrg(orgs.db.QueryRow(factset.OrgSelect+" WHERE u.UUID = $1;", uuid))
+	if err == sql.ErrNoRows {
+		return o, false, nil
 	}
-	defer mapRows.Close()
+	return o, err == nil, err
+}
 
-	if !mapRows.Next() {
-		return
+// orgJSON returns the org with the given UUID as JSON: the document
+// fsimporter materialised for it in fsOrgs, or, if there is none, as
+// getOrg assembles it.
+func (orgs *orgDB) orgJSON(uuid string) (doc []byte, found bool, err error) {
+	err = orgs.db.QueryRow(`SELECT ORG FROM fsOrgs WHERE UUID = $1;`, uuid).Scan(&doc)
+	if err == nil {
+		return doc, true, nil
 	}
-	if mapRows.Err() != nil {
-		panic(mapRows.Err)
+	if err != sql.ErrNoRows && !undefinedTable(err) {
+		return nil, false, err
 	}
 
-	var u uuidMapping
-	err = mapRows.Scan(
-		&u.UUID,
-		&u.FACTSET_ENTITY_ID,
-	)
-	if err != nil {
-		panic(err)
-	}
-
-	// entity
-	entRows, err := orgs.db.Query(`SELECT * from fsEntity WHERE FACTSET_ENTITY_ID = $1;`, u.FACTSET_ENTITY_ID)
-	if err != nil {
-		return
+	o, found, err := orgs.getOrg(uuid)
+	if err != nil || !found {
+		return nil, found, err
 	}
-	defer entRows.Close()
+	doc, err = json.Marshal(o)
+	return doc, err == nil, err
+}
 
-	if !entRows.Next() {
-		// this is a bug. log something or something?
-		return
+// getOrgs returns, as JSON, the orgs with the given UUIDs or
+// FACTSET_ENTITY_IDs, keyed by the id each was asked for. Ids that match no
+// org are left out. FACTSET_ENTITY_IDs are converted to the UUIDs they map
+// to, so that both are looked up by UUID. Stored orgs are read from fsOrgs
+// in one query, and any others are assembled in another.
+func (orgs *orgDB) getOrgs(ids []string) (map[string][]byte, error) {
+	asked := make(map[string][]string)
+	for _, id := range ids {
+		u := id
+		if uuid.Parse(id) == nil {
+			u = factset.UUIDFromFsid(id)
+		}
+		asked[u] = append(asked[u], id)
 	}
-	if entRows.Err() != nil {
-		panic(entRows.Err)
-	}
-
-	found = true
-
-	var e fsEntity
-	err = entRows.Scan(
-		&e.FACTSET_ENTITY_ID,
-		&e.ENTITY_NAME,
-		&e.ENTITY_PROPER_NAME,
-		&e.PRIMARY_SIC_CODE,
-		&e.INDUSTRY_CODE,
-		&e.SECTOR_CODE,
-		&e.ISO_COUNTRY,
-		&e.METRO_AREA,
-		&e.STATE_PROVINCE,
-		&e.ZIP_POSTAL_CODE,
-		&e.WEB_SITE,
-		&e.ENTITY_TYPE,
-		&e.ENTITY_SUB_TYPE,
-		&e.YEAR_FOUNDED,
-		&e.ISO_COUNTRY_INCORP,
-		&e.ISO_COUNTRY_COR,
-		&e.NACE_CODE,
-	)
-	if err != nil {
-		panic(err)
+	var uuids []string
+	for u := range asked {
+		uuids = append(uuids, u)
 	}
 
-	o.UUID = u.UUID
-	switch e.ENTITY_TYPE {
-	case "PUB":
-		o.Type = "PublicCompany"
-	case "EXT":
-		//o.Extinct = true
-		o.Type = "Organisation"
-	default:
-		o.Type = "Organisation"
+	docs := make(map[string][]byte)
+	found := make(map[string]bool)
+	add := func(u string, doc []byte) {
+		found[u] = true
+		for _, id := range asked[u] {
+			docs[id] = doc
+		}
 	}
 
-	o.PrefLabel = e.ENTITY_PROPER_NAME
-	o.ProperName = e.ENTITY_PROPER_NAME
-	o.HiddenLabel = e.ENTITY_NAME
-
-	o.YearFounded = e.YEAR_FOUNDED
-
-	o.AlternativeIdentifiers = alternativeIdentifiers{
-		FactsetIdentifier: e.FACTSET_ENTITY_ID,
-		UUIDs:             []string{u.UUID},
+	if err := orgs.storedOrgs(uuids, add); err != nil {
+		return nil, err
 	}
 
-	if e.INDUSTRY_CODE != "" {
-		o.IndustryClassification = icFromFsIc(e.INDUSTRY_CODE)
+	var rest []string
+	for _, u := range uuids {
+		if !found[u] {
+			rest = append(rest, u)
+		}
 	}
+	if len(rest) == 0 {
+		return docs, nil
+	}
+	return docs, orgs.assembledOrgs(rest, add)
+}
 
-	o.PostalCode = e.ZIP_POSTAL_CODE
-	o.CountryCode = e.ISO_COUNTRY
-	o.CountryOfIncorporation = e.ISO_COUNTRY_INCORP
-
-	// structure
-	structRows, err := orgs.db.Query(`SELECT * from fsStructure WHERE FACTSET_ENTITY_ID = $1;`, e.FACTSET_ENTITY_ID)
+// storedOrgs calls f with each org in fsOrgs whose UUID is one of uuids.
+func (orgs *orgDB) storedOrgs(uuids []string, f func(uuid string, doc []byte)) error {
+	rows, err := orgs.db.Query(`SELECT UUID, ORG FROM fsOrgs WHERE UUID = ANY($1);`, pq.Array(uuids))
+	if undefinedTable(err) {
+		return nil
+	}
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer structRows.Close()
-
-	if structRows.Next() {
-		var structure fsStructure
-		if err := structRows.Scan(
-			&structure.FACTSET_ENTITY_ID,
-			&structure.FACTSET_PARENT_ENTITY_ID,
-			&structure.FACTSET_ULTIMATE_PARENT_ENTITY_ID,
-		); err != nil {
-			panic(err)
-		}
+	defer rows.Close()
 
-		if structure.FACTSET_PARENT_ENTITY_ID != "" {
-			o.ParentOrganisation = uuidFromFsid(structure.FACTSET_PARENT_ENTITY_ID)
+	for rows.Next() {
+		var uuid string
+		var doc []byte
+		if err := rows.Scan(&uuid, &doc); err != nil {
+			return err
 		}
+		f(uuid, doc)
 	}
+	return rows.Err()
+}
 
-	// names
-	nameRows, err := orgs.db.Query(`SELECT * from fsNames WHERE FACTSET_ENTITY_ID = $1;`, e.FACTSET_ENTITY_ID)
+// assembledOrgs calls f with each org whose UUID is one of uuids, assembled
+// from the FactSet tables.
+func (orgs *orgDB) assembledOrgs(uuids []string, f func(uuid string, doc []byte)) error {
+	rows, err := orgs.db.Query(factset.OrgSelect+" WHERE u.UUID = ANY($1);", pq.Array(uuids))
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer nameRows.Close()
+	defer rows.Close()
 
-	for nameRows.Next() {
-		var nr fsNames
-		if err := nameRows.Scan(
-			&nr.FACTSET_ENTITY_ID,
-			&nr.ENTITY_NAME_TYPE,
-			&nr.ENTITY_NAME_VALUE,
-		); err != nil {
-			panic(err)
+	for rows.Next() {
+		o, err := factset.ScanOrg(rows)
+		if err != nil {
+			return err
 		}
-		switch nr.ENTITY_NAME_TYPE {
-		case "FORMER_NAME":
-			o.FormerNames = append(o.FormerNames, nr.ENTITY_NAME_VALUE)
-		case "SHORT_NAME":
-			o.ShortName = nr.ENTITY_NAME_VALUE
-		case "LEGAL_NAME":
-			o.LegalName = nr.ENTITY_NAME_VALUE
-		case "TRADE_DBA_NAME":
-			o.TradeNames = append(o.TradeNames, nr.ENTITY_NAME_VALUE)
-		case "LOCAL_NAME":
-			o.LocalNames = append(o.LocalNames, nr.ENTITY_NAME_VALUE)
-		default:
-			log.Printf("unknown name type %v - skipping\n", nr.ENTITY_NAME_TYPE)
+		doc, err := json.Marshal(o)
+		if err != nil {
+			return err
 		}
+		f(o.UUID, doc)
 	}
-	if nameRows.Err() != nil {
-		panic(nameRows.Err())
-	}
+	return rows.Err()
+}
 
-	// changes
-	changeRows, err := orgs.db.Query(`SELECT * from fsChanges WHERE FACTSET_ENTITY_ID = $1;`, e.FACTSET_ENTITY_ID)
+// resolveID returns the UUIDs of the orgs with the given identifier, of
+// type factset for a FACTSET_ENTITY_ID or of any ENTITY_ID_TYPE in
+// fsIdentifiers, such as LEI or CIK. The type is upper-cased before it is
+// compared with ENTITY_ID_TYPE, which FactSet delivers in upper case, so lei
+// finds LEI but a type stored in any other case is not found. An identifier
+// may belong to more than one org.
+func (orgs *orgDB) resolveID(idType string, id string) ([]string, error) {
+	var rows *sql.Rows
+	var err error
+	if strings.EqualFold(idType, "factset") {
+		// the UUID is derived from the FACTSET_ENTITY_ID, so only whether
+		// it is mapped needs looking up
+		rows, err = orgs.db.Query(`SELECT UUID FROM uuid_to_fsid WHERE UUID = $1;`, factset.UUIDFromFsid(id))
+	} else {
+		rows, err = orgs.db.Query(`
+SELECT DISTINCT u.UUID FROM fsIdentifiers i
+JOIN uuid_to_fsid u ON u.FACTSET_ENTITY_ID = i.FACTSET_ENTITY_ID
+WHERE i.ENTITY_ID_TYPE = $1 AND i.ENTITY_ID_VALUE = $2
+ORDER BY u.UUID;`, strings.ToUpper(idType), id)
+	}
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer changeRows.Close()
-	for changeRows.Next() {
-		//TODO
+	defer rows.Close()
+
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
 	}
+	return uuids, rows.Err()
+}
 
-	// identifiers
-	idRows, err := orgs.db.Query(`SELECT * from fsIdentifiers WHERE FACTSET_ENTITY_ID = $1;`, e.FACTSET_ENTITY_ID)
+// undefinedTable reports whether err is PostgreSQL's complaint about a
+// missing table, as when reading fsOrgs from a database loaded before
+// orgs were materialised.
+func undefinedTable(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "42P01"
+}
+
+// forEachOrg calls f with every org as JSON, in UUID order, starting after
+// the given UUID, or from the first if it is empty. The orgs are read from
+// fsOrgs or, in a database loaded before orgs were materialised, assembled
+// from the FactSet tables. Either way they are read a batch at a time
+// through a server-side cursor, so the whole set is never held in memory.
+func (orgs *orgDB) forEachOrg(ctx context.Context, after string, f func(doc []byte) error) error {
+	tx, err := orgs.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer idRows.Close()
-	for idRows.Next() {
-		var ident fsIdentifiers
-		if err := idRows.Scan(
-			&ident.FACTSET_ENTITY_ID,
-			&ident.ENTITY_ID_TYPE,
-			&ident.ENTITY_ID_VALUE,
-		); err != nil {
-			panic(err)
-		}
-		switch ident.ENTITY_ID_TYPE {
-		case "LEI":
-			o.AlternativeIdentifiers.LeiCode = ident.ENTITY_ID_VALUE
-		default:
-			log.Printf("unknown identifier type %s. skipping.\n", ident.ENTITY_ID_TYPE)
-		}
+	defer tx.Rollback()
 
+	var stored bool
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('fsOrgs') IS NOT NULL;`).Scan(&stored); err != nil {
+		return err
 	}
 
-	return
-}
-
-func icFromFsIc(fsic string) string {
-	return uuid.NewHash(md5.New(), emptyUUID, []byte(fsic), 3).String()
-}
+	// DECLARE cannot take parameters, so the UUID is quoted into it
+	q := fmt.Sprintf("SELECT ORG FROM fsOrgs WHERE UUID > %s ORDER BY UUID", pq.QuoteLiteral(after))
+	scan := func(rows *sql.Rows) (doc []byte, err error) {
+		err = rows.Scan(&doc)
+		return
+	}
+	if !stored {
+		q = fmt.Sprintf("%s WHERE u.UUID > %s ORDER BY u.UUID", factset.OrgSelect, pq.QuoteLiteral(after))
+		scan = func(rows *sql.Rows) ([]byte, error) {
+			o, err := factset.ScanOrg(rows)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(o)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DECLARE orgs NO SCROLL CURSOR FOR "+q+";"); err != nil {
+		return err
+	}
 
-func uuidFromFsid(fsid string) string {
-	md5data := md5.Sum([]byte(fsid))
-	return uuid.NewHash(md5.New(), emptyUUID, md5data[:], 3).String()
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM orgs;", orgBatchSize))
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			doc, err := scan(rows)
+			if err == nil {
+				err = f(doc)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		if n < orgBatchSize {
+			return nil
+		}
+	}
 }
 
 func (orgs *orgDB) size() (int, error) {
@@ -247,3 +282,52 @@ func (orgs *orgDB) forEachId(f func(id string) error) error {
 	}
 	return nil
 }
+
+// buildInfo returns the import and delta runs behind the current version.
+// found is false if the database has no record of them, as when it was
+// loaded before versioning.
+func (orgs *orgDB) buildInfo() (info buildInfo, found bool, err error) {
+	err = orgs.db.QueryRow(`SELECT to_regclass('fs_versions') IS NOT NULL AND to_regclass('import_runs') IS NOT NULL;`).Scan(&found)
+	if err != nil || !found {
+		return
+	}
+
+	err = orgs.db.QueryRow(`SELECT VERSION FROM fs_versions WHERE ACTIVATED_AT IS NOT NULL ORDER BY ACTIVATED_AT DESC LIMIT 1;`).Scan(&info.Version)
+	if err == sql.ErrNoRows {
+		return info, false, nil
+	}
+	if err != nil {
+		return
+	}
+
+	rows, err := orgs.db.Query(`
+SELECT KIND, SOURCE, SEQUENCE, CHECKSUM, STARTED_AT, FINISHED_AT, ROW_COUNTS, STATUS FROM import_runs
+WHERE VERSION = $1 ORDER BY STARTED_AT;`, info.Version)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	info.Runs = []importRun{}
+	for rows.Next() {
+		var r importRun
+		var sequence sql.NullInt64
+		var finished pq.NullTime
+		var counts []byte
+		if err = rows.Scan(&r.Kind, &r.Source, &sequence, &r.Checksum, &r.StartedAt, &finished, &counts, &r.Status); err != nil {
+			return
+		}
+		r.Sequence = sequence.Int64
+		if finished.Valid {
+			r.FinishedAt = &finished.Time
+		}
+		if counts != nil {
+			if err = json.Unmarshal(counts, &r.RowCounts); err != nil {
+				return
+			}
+		}
+		info.Runs = append(info.Runs, r)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// BenchmarkGetOrg measures assembling orgs from the FactSet tables of the
+// database given by FSIMPORT_TEST_DSN, a lib/pq connection string whose
+// search_path reaches them, such as
+// "postgres://localhost/factset?sslmode=disable&search_path=fs,public".
+// It is skipped if that is not set.
+func BenchmarkGetOrg(b *testing.B) {
+	dsn := os.Getenv("FSIMPORT_TEST_DSN")
+	if dsn == "" {
+		b.Skip("FSIMPORT_TEST_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	orgs := &orgDB{db}
+
+	rows, err := db.Query("SELECT UUID FROM uuid_to_fsid ORDER BY UUID LIMIT 1000;")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			b.Fatal(err)
+		}
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		b.Fatal(err)
+	}
+	if len(uuids) == 0 {
+		b.Skip("the database has no orgs")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, found, err := orgs.getOrg(uuids[i%len(uuids)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !found {
+			b.Fatalf("org %s not found", uuids[i%len(uuids)])
+		}
+	}
+}